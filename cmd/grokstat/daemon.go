@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/grokstat/grokstat"
+)
+
+const (
+	DEFAULT_MAX_REQUESTS    = 16
+	DEFAULT_REQUEST_TIMEOUT = 30 * time.Second
+	DAEMON_SHUTDOWN_TIMEOUT = 5 * time.Second
+)
+
+// Serves the HTTP API. The protocol collection is loaded once and shared by all requests.
+// Queries run on a pool of max-requests network engines which keep their sockets open between requests. The engines are made as they are first needed.
+//
+//	GET /protocols - the protocol list, same as show-protocols
+//	POST /query - runs the query described by the input JSON in the request body
+//	GET /metrics - the metrics of the hosts given at startup in the Prometheus text format
+//	GET /history - the recorded history, same as history-query with the host, group-by, from and to URL parameters
+type Daemon struct {
+	protColl       *grokstat.ProtocolCollection
+	messageChan    chan<- grokstat.ConsoleMsg
+	engines        chan *grokstat.Engine
+	requestTimeout time.Duration
+	counters       *grokstat.QueryCounters
+	exporter       *Exporter
+	history        *grokstat.History
+}
+
+func MakeDaemon(flags InputData, protColl *grokstat.ProtocolCollection, messageChan chan<- grokstat.ConsoleMsg) *Daemon {
+	maxRequests := flags.MaxRequests
+	if maxRequests <= 0 {
+		maxRequests = DEFAULT_MAX_REQUESTS
+	}
+	requestTimeout := time.Duration(flags.RequestTimeout) * time.Millisecond
+	if requestTimeout <= 0 {
+		requestTimeout = DEFAULT_REQUEST_TIMEOUT
+	}
+	counters := grokstat.MakeQueryCounters()
+	exporter := MakeExporter(flags, protColl, counters, messageChan)
+	engines := make(chan *grokstat.Engine, maxRequests)
+	for i := 0; i < maxRequests; i++ {
+		engines <- nil
+	}
+	return &Daemon{protColl: protColl, messageChan: messageChan, engines: engines, requestTimeout: requestTimeout, counters: counters, exporter: exporter}
+}
+
+// Closes the network engines. Has to be called once no requests are being served.
+func (d *Daemon) Close() {
+	for i := 0; i < cap(d.engines); i++ {
+		if engine := <-d.engines; engine != nil {
+			engine.Close()
+		}
+	}
+}
+
+func writeJsonResponse(w http.ResponseWriter, httpStatus int, output interface{}, err error, flags InputData) {
+	jsonOut, _ := FormJSONResponse(output, err, flags)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	w.Write([]byte(jsonOut + "\n"))
+}
+
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/protocols":
+		if r.Method != http.MethodGet {
+			writeJsonResponse(w, http.StatusMethodNotAllowed, nil, grokstat.InvalidRequestMethod, MakeInputData())
+			return
+		}
+		writeJsonResponse(w, http.StatusOK, map[string]interface{}{"protocols": d.protColl.Map()}, nil, MakeInputData())
+	case "/query":
+		if r.Method != http.MethodPost {
+			writeJsonResponse(w, http.StatusMethodNotAllowed, nil, grokstat.InvalidRequestMethod, MakeInputData())
+			return
+		}
+		d.serveQuery(w, r)
+	case "/metrics":
+		if r.Method != http.MethodGet {
+			writeJsonResponse(w, http.StatusMethodNotAllowed, nil, grokstat.InvalidRequestMethod, MakeInputData())
+			return
+		}
+		d.exporter.ServeHTTP(w, r)
+	case "/history":
+		if r.Method != http.MethodGet {
+			writeJsonResponse(w, http.StatusMethodNotAllowed, nil, grokstat.InvalidRequestMethod, MakeInputData())
+			return
+		}
+		d.serveHistory(w, r)
+	default:
+		writeJsonResponse(w, http.StatusNotFound, nil, grokstat.InvalidRequestPath, MakeInputData())
+	}
+}
+
+func (d *Daemon) serveQuery(w http.ResponseWriter, r *http.Request) {
+	flags := MakeInputData()
+	if err := json.NewDecoder(r.Body).Decode(&flags); err != nil {
+		writeJsonResponse(w, http.StatusBadRequest, nil, err, flags)
+		return
+	}
+
+	if flags.OutputFormat != "" && flags.OutputFormat != OUTPUT_FORMAT_JSON && flags.OutputFormat != OUTPUT_FORMAT_XML && flags.OutputFormat != OUTPUT_FORMAT_JSONL {
+		writeJsonResponse(w, http.StatusBadRequest, nil, grokstat.InvalidOutputFormat, flags)
+		return
+	}
+
+	protColl := d.protColl
+	if len(flags.Overrides) > 0 {
+		protColl = d.protColl.Copy()
+		for protocolId, overrides := range flags.Overrides {
+			if !protColl.Override(protocolId, overrides) {
+				writeJsonResponse(w, http.StatusBadRequest, nil, grokstat.InvalidProtocol, flags)
+				return
+			}
+		}
+	}
+
+	hosts := grokstat.MakeHostProtocolIdPairs(flags.Hosts)
+	if len(hosts) == 0 {
+		writeJsonResponse(w, http.StatusBadRequest, nil, grokstat.NoHosts, flags)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), d.requestTimeout)
+	defer cancel()
+
+	var engine *grokstat.Engine
+	select {
+	case engine = <-d.engines:
+		defer func() { d.engines <- engine }()
+	case <-ctx.Done():
+		writeJsonResponse(w, http.StatusServiceUnavailable, nil, grokstat.TooManyRequests, flags)
+		return
+	}
+	if engine == nil {
+		var err error
+		if engine, err = grokstat.MakeEngine(d.messageChan); err != nil {
+			writeJsonResponse(w, http.StatusInternalServerError, nil, err, flags)
+			return
+		}
+	}
+
+	// The request timeout ends the query with the results collected so far, the client going away cancels it.
+	queryCtx, queryCancel := context.WithCancel(r.Context())
+	defer queryCancel()
+	opts := MakeQueryOptions(flags, protColl, d.messageChan, nil)
+	opts.Counters = d.counters
+	if deadline, _ := ctx.Deadline(); opts.Timeout <= 0 || time.Until(deadline) < opts.Timeout {
+		opts.Timeout = time.Until(deadline)
+	}
+	query := func() (grokstat.QueryResult, error) {
+		queryTime := time.Now()
+		result, err := engine.Query(queryCtx, hosts, opts)
+		if err == nil {
+			RecordHistory(d.history, result, queryTime, d.messageChan)
+		}
+		return result, err
+	}
+
+	switch flags.OutputFormat {
+	case OUTPUT_FORMAT_JSONL:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher, _ := w.(http.Flusher)
+		opts.EntryHandler = func(entry grokstat.ServerEntry) {
+			line, _ := FormJSONLine(entry)
+			w.Write([]byte(line + "\n"))
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		result, err := query()
+		summary, _ := FormJSONLinesSummary(result, err)
+		w.Write([]byte(summary + "\n"))
+	case OUTPUT_FORMAT_XML:
+		result, err := query()
+		xmlOut, _ := FormXMLResponse(result.Servers, err, flags)
+		w.Header().Set("Content-Type", "application/xml")
+		if err != nil {
+			w.WriteHeader(grokstat.ErrorStatus(err))
+		}
+		w.Write([]byte(xmlOut + "\n"))
+	default:
+		result, err := query()
+		if err != nil {
+			writeJsonResponse(w, http.StatusInternalServerError, nil, err, flags)
+			return
+		}
+		writeJsonResponse(w, http.StatusOK, MakeQueryOutput(result), nil, flags)
+	}
+}
+
+// Runs the history query on the history the daemon records in. The history file is held by the daemon while it runs, so other processes cannot read it.
+func (d *Daemon) serveHistory(w http.ResponseWriter, r *http.Request) {
+	flags := MakeInputData()
+	if d.history == nil {
+		writeJsonResponse(w, http.StatusNotFound, nil, grokstat.NoHistoryPath, flags)
+		return
+	}
+
+	params := r.URL.Query()
+	query := HistoryQuery{Host: params.Get("host"), GroupBy: params.Get("group-by")}
+	for param, value := range map[string]*int64{"from": &query.From, "to": &query.To} {
+		if params.Get(param) == "" {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(params.Get(param), 10, 64)
+		if err != nil {
+			writeJsonResponse(w, http.StatusBadRequest, nil, err, flags)
+			return
+		}
+		*value = timestamp
+	}
+
+	output, err := QueryHistory(d.history, query)
+	switch err {
+	case nil:
+		writeJsonResponse(w, http.StatusOK, output, nil, flags)
+	case grokstat.InvalidHistoryGroup:
+		writeJsonResponse(w, http.StatusBadRequest, nil, err, flags)
+	default:
+		writeJsonResponse(w, http.StatusInternalServerError, nil, err, flags)
+	}
+}
+
+// Serves the HTTP API until the process is interrupted. The hosts given at startup are scraped in the background for the metrics.
+func RunDaemon(flags InputData, protColl *grokstat.ProtocolCollection, messageChan chan<- grokstat.ConsoleMsg) error {
+	daemon := MakeDaemon(flags, protColl, messageChan)
+	defer daemon.Close()
+	server := &http.Server{Addr: flags.Listen, Handler: daemon}
+
+	exporterCtx, exporterCancel := context.WithCancel(context.Background())
+	exporterDoneChan := make(chan struct{})
+	defer func() {
+		exporterCancel()
+		<-exporterDoneChan
+	}()
+	history, err := OpenHistory(flags)
+	if err != nil {
+		close(exporterDoneChan)
+		return err
+	}
+	if history != nil {
+		defer history.Close()
+	}
+	daemon.history = history
+	daemon.exporter.history = history
+	go func() {
+		defer close(exporterDoneChan)
+		if len(daemon.exporter.hosts) > 0 {
+			daemon.exporter.Run(exporterCtx)
+		}
+	}()
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signalChan)
+
+	serveErrChan := make(chan error, 1)
+	go func() {
+		serveErrChan <- server.ListenAndServe()
+	}()
+	messageChan <- grokstat.ConsoleMsg{Type: grokstat.MSG_MINOR, Message: "Serving the HTTP API on " + flags.Listen + "."}
+
+	select {
+	case err := <-serveErrChan:
+		return err
+	case <-signalChan:
+		ctx, cancel := context.WithTimeout(context.Background(), DAEMON_SHUTDOWN_TIMEOUT)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
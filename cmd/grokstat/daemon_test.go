@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grokstat/grokstat"
+)
+
+func TestDaemon(t *testing.T) {
+	protColl := grokstat.LoadProtocols([]grokstat.ProtocolConfig{grokstat.ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+	messageChan := make(chan grokstat.ConsoleMsg, 100)
+	daemon := MakeDaemon(MakeInputData(), protColl, messageChan)
+	defer daemon.Close()
+	server := httptest.NewServer(daemon)
+	defer server.Close()
+
+	var response struct {
+		Status int                    `json:"status"`
+		Output map[string]interface{} `json:"output"`
+	}
+
+	protocolsResponse, err := http.Get(server.URL + "/protocols")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(protocolsResponse.Body).Decode(&response)
+	protocolsResponse.Body.Close()
+	if protocols, _ := response.Output["protocols"].(map[string]interface{}); protocolsResponse.StatusCode != http.StatusOK || protocols["mumbles"] == nil {
+		t.Errorf(grokstat.ErrorOut("mumbles", response.Output))
+	}
+
+	for body, expectation := range map[string]int{
+		`{"hosts": {"bogus": ["127.0.0.1:1"]}}`: http.StatusOK,
+		`{"hosts": {}}`:                         http.StatusBadRequest,
+		`{"hosts": {"bogus": ["127.0.0.1:1"]}, "overrides": {"bogus": {"Port": "1"}}}`: http.StatusBadRequest,
+		`{"hosts": {"bogus": ["127.0.0.1:1"]}, "output-format": "csv"}`:                http.StatusBadRequest,
+	} {
+		queryResponse, err := http.Post(server.URL+"/query", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		queryResponse.Body.Close()
+		if queryResponse.StatusCode != expectation {
+			t.Errorf(grokstat.ErrorOut(expectation, queryResponse.StatusCode))
+		}
+	}
+
+	getResponse, err := http.Get(server.URL + "/query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	getResponse.Body.Close()
+	if getResponse.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf(grokstat.ErrorOut(http.StatusMethodNotAllowed, getResponse.StatusCode))
+	}
+}
+
+func TestDaemonHistory(t *testing.T) {
+	protColl := grokstat.LoadProtocols([]grokstat.ProtocolConfig{grokstat.ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+	messageChan := make(chan grokstat.ConsoleMsg, 100)
+	dir, dErr := ioutil.TempDir("", "grokstat")
+	if dErr != nil {
+		t.Fatal(dErr)
+	}
+	defer os.RemoveAll(dir)
+	history, err := grokstat.OpenHistory(filepath.Join(dir, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+	daemon := MakeDaemon(MakeInputData(), protColl, messageChan)
+	defer daemon.Close()
+	daemon.history = history
+	server := httptest.NewServer(daemon)
+	defer server.Close()
+	expectation := 2
+
+	for i := 0; i < expectation; i++ {
+		queryResponse, err := http.Post(server.URL+"/query", "application/json", strings.NewReader(`{"hosts": {"mumbles": ["127.0.0.1:1"]}, "timeout": 200}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		queryResponse.Body.Close()
+	}
+
+	snapshots, err := history.Series("127.0.0.1:1", time.Now().Add(-time.Minute), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != expectation {
+		t.Errorf(grokstat.ErrorOut(expectation, snapshots))
+	}
+
+	historyResponse, err := http.Get(server.URL + "/history?host=127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer historyResponse.Body.Close()
+	var historyOutput struct {
+		Output struct {
+			Series []grokstat.HistorySnapshot `json:"series"`
+		} `json:"output"`
+	}
+	if err := json.NewDecoder(historyResponse.Body).Decode(&historyOutput); err != nil {
+		t.Fatal(err)
+	}
+	if len(historyOutput.Output.Series) != expectation {
+		t.Errorf(grokstat.ErrorOut(expectation, historyOutput.Output.Series))
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grokstat/grokstat"
+)
+
+const DEFAULT_SCRAPE_INTERVAL = 60 * time.Second
+
+// Periodically queries the configured hosts and exposes the results in the Prometheus text format.
+type Exporter struct {
+	sync.Mutex
+	protColl    *grokstat.ProtocolCollection
+	hosts       []grokstat.HostProtocolIdPair
+	flags       InputData
+	interval    time.Duration
+	counters    *grokstat.QueryCounters
+	history     *grokstat.History
+	messageChan chan<- grokstat.ConsoleMsg
+
+	servers        []grokstat.ServerEntry
+	scrapeTime     time.Time
+	scrapeDuration time.Duration
+}
+
+func MakeExporter(flags InputData, protColl *grokstat.ProtocolCollection, counters *grokstat.QueryCounters, messageChan chan<- grokstat.ConsoleMsg) *Exporter {
+	interval := time.Duration(flags.ScrapeInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = DEFAULT_SCRAPE_INTERVAL
+	}
+	return &Exporter{protColl: protColl, hosts: grokstat.MakeHostProtocolIdPairs(flags.Hosts), flags: flags, interval: interval, counters: counters, messageChan: messageChan}
+}
+
+// Queries the hosts once on the network engine and stores the results.
+func (e *Exporter) Scrape(ctx context.Context, engine *grokstat.Engine) {
+	start := time.Now()
+	opts := MakeQueryOptions(e.flags, e.protColl, e.messageChan, nil)
+	opts.Counters = e.counters
+	if opts.Timeout <= 0 || opts.Timeout > e.interval {
+		opts.Timeout = e.interval
+	}
+	result, err := engine.Query(ctx, e.hosts, opts)
+	if err != nil {
+		e.messageChan <- grokstat.ConsoleMsg{Type: grokstat.MSG_MINOR, Message: fmt.Sprintf("Scrape failed - %s", err.Error())}
+		return
+	}
+
+	RecordHistory(e.history, result, start, e.messageChan)
+
+	e.Lock()
+	defer e.Unlock()
+	e.servers = result.Servers
+	e.scrapeTime = start
+	e.scrapeDuration = time.Since(start)
+}
+
+// Scrapes the hosts at the configured interval until the context is cancelled. The scrapes run on a network engine of their own, which keeps its sockets open between them.
+func (e *Exporter) Run(ctx context.Context) {
+	engine, err := grokstat.MakeEngine(e.messageChan)
+	if err != nil {
+		e.messageChan <- grokstat.ConsoleMsg{Type: grokstat.MSG_MINOR, Message: fmt.Sprintf("Scrape failed - %s", err.Error())}
+		return
+	}
+	defer engine.Close()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		e.Scrape(ctx, engine)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type metricSample struct {
+	labels [][2]string
+	value  float64
+}
+
+func formatLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels))
+	for _, label := range labels {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, label[0], metricsLabelEscaper.Replace(label[1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func writeMetric(buf *bytes.Buffer, name string, metricType string, help string, samples []metricSample) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	for _, sample := range samples {
+		fmt.Fprintf(buf, "%s%s %s\n", name, formatLabels(sample.labels), strconv.FormatFloat(sample.value, 'f', -1, 64))
+	}
+}
+
+func counterSamples(counter map[string]uint64) []metricSample {
+	protocolIds := make([]string, 0, len(counter))
+	for protocolId := range counter {
+		protocolIds = append(protocolIds, protocolId)
+	}
+	sort.Strings(protocolIds)
+
+	samples := []metricSample{}
+	for _, protocolId := range protocolIds {
+		samples = append(samples, metricSample{labels: [][2]string{{"protocol", protocolId}}, value: float64(counter[protocolId])})
+	}
+	return samples
+}
+
+// Formats the last scrape results and the counters in the Prometheus text format.
+func (e *Exporter) Metrics() string {
+	e.Lock()
+	servers := make([]grokstat.ServerEntry, len(e.servers))
+	copy(servers, e.servers)
+	scrapeTime := e.scrapeTime
+	scrapeDuration := e.scrapeDuration
+	e.Unlock()
+
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Host < servers[j].Host })
+
+	var up, players, maxPlayers, bots, ping, info []metricSample
+	for _, entry := range servers {
+		labels := [][2]string{{"host", entry.Host}, {"protocol", entry.Protocol}}
+		isUp := entry.Error == nil
+		if isUp {
+			up = append(up, metricSample{labels: labels, value: 1})
+		} else {
+			up = append(up, metricSample{labels: labels, value: 0})
+			continue
+		}
+		mapLabels := [][2]string{{"host", entry.Host}, {"protocol", entry.Protocol}, {"map", entry.Terrain}}
+		players = append(players, metricSample{labels: mapLabels, value: float64(entry.NumClients)})
+		maxPlayers = append(maxPlayers, metricSample{labels: mapLabels, value: float64(entry.MaxClients)})
+		bots = append(bots, metricSample{labels: mapLabels, value: float64(entry.NumBots)})
+		ping = append(ping, metricSample{labels: labels, value: float64(entry.Ping) / 1000})
+		info = append(info, metricSample{labels: [][2]string{{"host", entry.Host}, {"protocol", entry.Protocol}, {"map", entry.Terrain}, {"name", entry.Name}, {"gametype", entry.GameType}}, value: 1})
+	}
+
+	buf := &bytes.Buffer{}
+	writeMetric(buf, "grokstat_server_up", "gauge", "Whether the server answered the last scrape.", up)
+	writeMetric(buf, "grokstat_server_players", "gauge", "Number of players on the server.", players)
+	writeMetric(buf, "grokstat_server_max_players", "gauge", "Maximum number of players on the server.", maxPlayers)
+	writeMetric(buf, "grokstat_server_bots", "gauge", "Number of bots on the server.", bots)
+	writeMetric(buf, "grokstat_server_ping_seconds", "gauge", "Round-trip time to the server.", ping)
+	writeMetric(buf, "grokstat_server_info", "gauge", "Server information.", info)
+
+	counters := e.counters.Snapshot()
+	writeMetric(buf, "grokstat_packets_sent_total", "counter", "Packets sent by protocol.", counterSamples(counters.PacketsSent))
+	writeMetric(buf, "grokstat_packets_received_total", "counter", "Packets received by protocol.", counterSamples(counters.PacketsReceived))
+	writeMetric(buf, "grokstat_parse_errors_total", "counter", "Responses which could not be parsed by protocol.", counterSamples(counters.ParseErrors))
+
+	if !scrapeTime.IsZero() {
+		writeMetric(buf, "grokstat_last_scrape_timestamp_seconds", "gauge", "Start time of the last successful scrape.", []metricSample{{value: float64(scrapeTime.Unix())}})
+		writeMetric(buf, "grokstat_last_scrape_duration_seconds", "gauge", "Duration of the last successful scrape.", []metricSample{{value: scrapeDuration.Seconds()}})
+	}
+
+	return buf.String()
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(e.Metrics()))
+}
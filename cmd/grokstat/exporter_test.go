@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grokstat/grokstat"
+)
+
+func TestExporterMetrics(t *testing.T) {
+	counters := grokstat.MakeQueryCounters()
+	counters.PacketSent("q3s")
+	counters.PacketSent("q3s")
+	counters.ParseError("a2s")
+
+	exporter := MakeExporter(MakeInputData(), grokstat.MakeProtocolCollection(), counters, nil)
+	exporter.servers = []grokstat.ServerEntry{
+		{Protocol: "q3s", Host: "127.0.0.1:27960", Status: 200, Name: `"Quoted" server`, Terrain: "q3dm17", NumClients: 3, MaxClients: 8, Ping: 42},
+		{Protocol: "q3s", Host: "127.0.0.2:27960", Status: 503, Error: grokstat.ServerDown},
+	}
+
+	result := exporter.Metrics()
+	for _, expectation := range []string{
+		"# TYPE grokstat_server_up gauge\n",
+		`grokstat_server_up{host="127.0.0.1:27960",protocol="q3s"} 1`,
+		`grokstat_server_up{host="127.0.0.2:27960",protocol="q3s"} 0`,
+		`grokstat_server_players{host="127.0.0.1:27960",protocol="q3s",map="q3dm17"} 3`,
+		`grokstat_server_max_players{host="127.0.0.1:27960",protocol="q3s",map="q3dm17"} 8`,
+		`grokstat_server_ping_seconds{host="127.0.0.1:27960",protocol="q3s"} 0.042`,
+		`name="\"Quoted\" server"`,
+		`grokstat_packets_sent_total{protocol="q3s"} 2`,
+		`grokstat_parse_errors_total{protocol="a2s"} 1`,
+	} {
+		if !strings.Contains(result, expectation) {
+			t.Errorf(grokstat.ErrorOut(expectation, result))
+		}
+	}
+
+	if strings.Contains(result, `grokstat_server_players{host="127.0.0.2:27960"`) {
+		t.Errorf("Player count present for the server which is down:\n%s", result)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grokstat/grokstat"
+)
+
+const DEFAULT_HISTORY_RANGE = 7 * 24 * time.Hour
+
+// Retrieves the time series of a host, or aggregates of all hosts by protocol or map if no host is given. Times are Unix timestamps in seconds, the last week by default.
+type HistoryQuery struct {
+	Host    string `json:"host"`
+	GroupBy string `json:"group-by"`
+	From    int64  `json:"from"`
+	To      int64  `json:"to"`
+}
+
+// Opens the history file given in the flags, nil if there is none.
+func OpenHistory(flags InputData) (*grokstat.History, error) {
+	if flags.HistoryPath == "" {
+		return nil, nil
+	}
+	return grokstat.OpenHistory(flags.HistoryPath)
+}
+
+// Records the query results in the history, if any.
+func RecordHistory(history *grokstat.History, result grokstat.QueryResult, t time.Time, messageChan chan<- grokstat.ConsoleMsg) {
+	if history == nil {
+		return
+	}
+	if err := history.Record(result.Servers, t); err != nil {
+		messageChan <- grokstat.ConsoleMsg{Type: grokstat.MSG_MINOR, Message: fmt.Sprintf("Error recording history - %s", err.Error())}
+	}
+}
+
+// Records the query results in the history file given in the flags, if any. The file is only held while recording, so that other processes can read it in between.
+func RecordHistoryFile(flags InputData, result grokstat.QueryResult, t time.Time, messageChan chan<- grokstat.ConsoleMsg) {
+	history, err := OpenHistory(flags)
+	if err != nil {
+		messageChan <- grokstat.ConsoleMsg{Type: grokstat.MSG_MINOR, Message: fmt.Sprintf("Error recording history - %s", err.Error())}
+		return
+	}
+	if history == nil {
+		return
+	}
+	defer history.Close()
+	RecordHistory(history, result, t, messageChan)
+}
+
+// Runs the history query on the history file given in the flags and returns its output.
+func RunHistoryQuery(flags InputData) (interface{}, error) {
+	history, err := OpenHistory(flags)
+	if err != nil {
+		return nil, err
+	}
+	if history == nil {
+		return nil, grokstat.NoHistoryPath
+	}
+	defer history.Close()
+	return QueryHistory(history, *flags.HistoryQuery)
+}
+
+// Runs the history query on the open history and returns its output.
+func QueryHistory(history *grokstat.History, query HistoryQuery) (interface{}, error) {
+	to := time.Now()
+	if query.To > 0 {
+		to = time.Unix(query.To, 0)
+	}
+	from := to.Add(-DEFAULT_HISTORY_RANGE)
+	if query.From > 0 {
+		from = time.Unix(query.From, 0)
+	}
+
+	if query.Host != "" {
+		series, sErr := history.Series(query.Host, from, to)
+		return map[string]interface{}{"series": series}, sErr
+	}
+
+	groupBy := query.GroupBy
+	if groupBy == "" {
+		groupBy = grokstat.HISTORY_GROUP_BY_PROTOCOL
+	}
+	aggregates, aErr := history.Aggregate(groupBy, from, to)
+	return map[string]interface{}{"aggregates": aggregates}, aErr
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/grokstat/grokstat"
+)
+
+// The last line of the JSON Lines output.
+type JsonLinesSummary struct {
+	Version    string            `json:"version"`
+	Status     int               `json:"status"`
+	Message    string            `json:"message"`
+	Summary    bool              `json:"summary"`
+	ServerList []string          `json:"server-list"`
+	Errors     map[string]string `json:"errors"`
+}
+
+// FormJSONLine creates a single line of JSON Lines output out of the server entry.
+var FormJSONLine = func(entry grokstat.ServerEntry) (string, error) {
+	jsonOut, jsonErr := json.Marshal(entry)
+
+	if jsonErr != nil {
+		jsonOut = []byte(`{"status": 500, "message": "JSON marshaller error."}`)
+	}
+
+	return string(jsonOut), jsonErr
+}
+
+// FormJSONLinesSummary creates the summary line which ends the JSON Lines output.
+var FormJSONLinesSummary = func(result grokstat.QueryResult, err error) (string, error) {
+	summary := JsonLinesSummary{Version: grokstat.VERSION, Summary: true, ServerList: []string{}, Errors: map[string]string{}}
+
+	if err != nil {
+		summary.Status = 500
+		summary.Message = err.Error()
+	} else {
+		summary.Status = 200
+		summary.Message = grokstat.OK.Error()
+	}
+
+	for _, entry := range result.Servers {
+		if entry.Error == nil {
+			summary.ServerList = append(summary.ServerList, entry.Host)
+		}
+	}
+	for host, hostErr := range result.Errors {
+		summary.Errors[host] = hostErr.Error()
+	}
+
+	jsonOut, jsonErr := json.Marshal(summary)
+
+	if jsonErr != nil {
+		jsonOut = []byte(`{"status": 500, "message": "JSON marshaller error."}`)
+	}
+
+	return string(jsonOut), jsonErr
+}
@@ -0,0 +1,314 @@
+/*
+grokstat is a tool for querying game servers for various information: server list, player count, active map etc
+
+The program takes protocol name and remote ip address as arguments, fetches information from the remote server, parses it and outputs back as JSON. As convenience the status and message are also provided.
+
+grokstat uses JSON input instead of command line flags. The JSON input is structured as follows:
+
+	hosts - map of string keys and string array values - hosts to query, hosts of unknown type can be listed under "auto"
+	show-protocols - boolean - if true, show protocols and exit
+	output-lvl - int - tune the output from bare JSON to full-fledged debug
+	custom-config-path - path of custom config file to be used
+	overrides - map of protocol ids and maps of protocol information to override, e.g. Region and Filter for steam
+	timeout - int - overall query duration limit in milliseconds, unlimited by default
+	idle-timeout - int - the query ends after this many milliseconds without network traffic, 5000 by default
+	probes - int - number of ping probes sent to each server, ping statistics are reported if more than one
+	probe-interval - int - interval between the ping probes in milliseconds, 500 by default
+	send-rate - int - packets sent per second, 500 by default, negative for no limit
+	max-in-flight - int - number of servers queried at once, 1024 by default, negative for no limit
+	output-format - string - "json" by default, "xml" for QStat-style XML output of the query results or "jsonl" for JSON Lines output, one line per server as soon as it is queried followed by a summary line
+	listen - string - address to serve the HTTP API on instead of running a single query, e.g. ":8080"
+	max-requests - int - number of HTTP API requests served at once, 16 by default
+	request-timeout - int - duration limit of each HTTP API request in milliseconds, 30000 by default
+	scrape-interval - int - with the HTTP API, the hosts are queried at this interval in milliseconds for the metrics, 60000 by default
+	watch-interval - int - if set, the hosts are queried at this interval in milliseconds until interrupted and the changes are printed as JSON events, one per line
+	history-path - string - path of the history file the query results are recorded in, also used by the watch mode and the metrics
+	history-query - object - if set, print the recorded history and exit: time series of "host", or aggregates of all hosts by "group-by" protocol or map, within "from" and "to" Unix timestamps, the last week by default. The HTTP API holds the history file while it is served, use GET /history instead
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/grokstat/grokstat"
+)
+
+const (
+	OUTPUT_FORMAT_JSON  = "json"
+	OUTPUT_FORMAT_XML   = "xml"
+	OUTPUT_FORMAT_JSONL = "jsonl"
+)
+
+type InputData struct {
+	Hosts          map[string][]string          `json:"hosts"`
+	ShowProtocols  bool                         `json:"show-protocols"`
+	OutputLvl      int                          `json:"output-lvl"`
+	ConfigPath     string                       `json:"config-path"`
+	Overrides      map[string]map[string]string `json:"overrides"`
+	Timeout        int                          `json:"timeout"`
+	IdleTimeout    int                          `json:"idle-timeout"`
+	Probes         int                          `json:"probes"`
+	ProbeInterval  int                          `json:"probe-interval"`
+	SendRate       int                          `json:"send-rate"`
+	MaxInFlight    int                          `json:"max-in-flight"`
+	OutputFormat   string                       `json:"output-format"`
+	Listen         string                       `json:"listen"`
+	MaxRequests    int                          `json:"max-requests"`
+	RequestTimeout int                          `json:"request-timeout"`
+	ScrapeInterval int                          `json:"scrape-interval"`
+	WatchInterval  int                          `json:"watch-interval"`
+	HistoryPath    string                       `json:"history-path"`
+	HistoryQuery   *HistoryQuery                `json:"history-query,omitempty"`
+}
+
+func MakeInputData() InputData {
+	return InputData{Hosts: make(map[string][]string), Overrides: make(map[string]map[string]string)}
+}
+
+type JsonResponse struct {
+	Version string      `json:"version"`
+	Status  int         `json:"status"`
+	Message string      `json:"message"`
+	Flags   InputData   `json:"input-flags"`
+	Output  interface{} `json:"output"`
+}
+
+// FormJSONResponse creates a JSON string out of Grokstat output.
+var FormJSONResponse = func(output interface{}, err error, flags InputData) (string, error) {
+	result := JsonResponse{Version: grokstat.VERSION, Flags: flags}
+
+	if err != nil {
+		result.Output = make(map[string]interface{})
+		result.Status = 500
+		result.Message = err.Error()
+	} else {
+		result.Output = output
+		result.Status = 200
+		result.Message = grokstat.OK.Error()
+	}
+
+	jsonOut, jsonErr := json.Marshal(result)
+
+	if jsonErr != nil {
+		jsonOut = []byte(`{"status": 500, "message": "JSON marshaller error."}`)
+	}
+
+	return string(jsonOut), jsonErr
+}
+
+// Makes the query options out of the input flags.
+func MakeQueryOptions(flags InputData, protColl *grokstat.ProtocolCollection, messageChan chan<- grokstat.ConsoleMsg, entryHandler func(grokstat.ServerEntry)) grokstat.QueryOptions {
+	return grokstat.QueryOptions{Protocols: protColl, MessageChan: messageChan, Timeout: time.Duration(flags.Timeout) * time.Millisecond, IdleTimeout: time.Duration(flags.IdleTimeout) * time.Millisecond, Probes: flags.Probes, ProbeInterval: time.Duration(flags.ProbeInterval) * time.Millisecond, SendRate: flags.SendRate, MaxInFlight: flags.MaxInFlight, EntryHandler: entryHandler}
+}
+
+// Makes the JSON output of the query results.
+func MakeQueryOutput(result grokstat.QueryResult) map[string]interface{} {
+	serverList := []string{}
+	for _, entry := range result.Servers {
+		if entry.Error == nil {
+			serverList = append(serverList, entry.Host)
+		}
+	}
+	hostErrors := map[string]string{}
+	for host, hostErr := range result.Errors {
+		hostErrors[host] = hostErr.Error()
+	}
+	return map[string]interface{}{"server-list": serverList, "servers": result.Servers, "errors": hostErrors}
+}
+
+func CleanupMessageChan(messageChan chan grokstat.ConsoleMsg, endChan <-chan struct{}) {
+	close(messageChan)
+	<-endChan
+}
+
+var PrintProtocols = func(messageChan chan grokstat.ConsoleMsg, protColl *grokstat.ProtocolCollection, flags InputData) {
+	output := make(map[string]interface{})
+	output["protocols"] = protColl.Map()
+
+	PrintJsonResponse(messageChan, output, nil, flags)
+}
+
+var PrintError = func(messageChan chan grokstat.ConsoleMsg, err error, flags InputData) {
+	if flags.OutputFormat == OUTPUT_FORMAT_XML {
+		PrintXMLResponse(messageChan, nil, err, flags)
+		return
+	}
+	PrintJsonResponse(messageChan, nil, err, flags)
+}
+
+var PrintJsonResponse = func(messageChan chan grokstat.ConsoleMsg, output interface{}, err error, flags InputData) {
+	jsonOut, _ := FormJSONResponse(output, err, flags)
+	messageChan <- grokstat.ConsoleMsg{Type: grokstat.MSG_MAJOR, Message: jsonOut}
+}
+
+var PrintXMLResponse = func(messageChan chan grokstat.ConsoleMsg, entries []grokstat.ServerEntry, err error, flags InputData) {
+	xmlOut, _ := FormXMLResponse(entries, err, flags)
+	messageChan <- grokstat.ConsoleMsg{Type: grokstat.MSG_MAJOR, Message: xmlOut}
+}
+
+var DefaultConfigBinPath = "data/grokstat.toml"
+
+func conditionalPrint(message grokstat.ConsoleMsg, outputLvl int, useLogging bool) {
+	if message.Type <= outputLvl {
+		if useLogging {
+			log.Println(message.Message)
+		} else {
+			fmt.Println(message.Message)
+		}
+	}
+}
+
+func outputLoop(messageChan <-chan grokstat.ConsoleMsg, messageEndChan chan<- struct{}, outputLvl int) {
+	for {
+		message, mOk := <-messageChan
+		if mOk {
+			conditionalPrint(message, outputLvl, outputLvl >= grokstat.MSG_DEBUG)
+		} else {
+			messageEndChan <- struct{}{}
+			return
+		}
+	}
+}
+
+func main() {
+	args := os.Args
+	var argJsonText string
+	var jsonText string
+
+	if len(args) > 1 {
+		argJsonText = args[1]
+	}
+
+	if argJsonText != "" {
+		jsonText = argJsonText
+	} else {
+		reader := bufio.NewReader(os.Stdin)
+		jsonText, _ = reader.ReadString('\n')
+	}
+
+	jsonFlags := MakeInputData()
+	jsonFlags.OutputLvl = grokstat.DEFAULT_OUTPUT_LVL
+	jsonErr := json.Unmarshal([]byte(jsonText), &jsonFlags)
+
+	messageChan := make(chan grokstat.ConsoleMsg)
+	messageEndChan := make(chan struct{})
+
+	outputLvl := jsonFlags.OutputLvl
+
+	go outputLoop(messageChan, messageEndChan, outputLvl)
+
+	if jsonErr != nil {
+		PrintError(messageChan, jsonErr, jsonFlags)
+		CleanupMessageChan(messageChan, messageEndChan)
+		return
+	}
+
+	if jsonFlags.OutputFormat != "" && jsonFlags.OutputFormat != OUTPUT_FORMAT_JSON && jsonFlags.OutputFormat != OUTPUT_FORMAT_XML && jsonFlags.OutputFormat != OUTPUT_FORMAT_JSONL {
+		PrintError(messageChan, grokstat.InvalidOutputFormat, jsonFlags)
+		CleanupMessageChan(messageChan, messageEndChan)
+		return
+	}
+
+	if jsonFlags.HistoryQuery != nil {
+		output, err := RunHistoryQuery(jsonFlags)
+		if err != nil {
+			PrintError(messageChan, err, jsonFlags)
+		} else {
+			PrintJsonResponse(messageChan, output, nil, jsonFlags)
+		}
+		CleanupMessageChan(messageChan, messageEndChan)
+		return
+	}
+
+	hostMap := jsonFlags.Hosts
+	showProtocols := jsonFlags.ShowProtocols
+	configPath := jsonFlags.ConfigPath
+
+	if configPath == "" {
+		PrintError(messageChan, grokstat.NoConfig, jsonFlags)
+		CleanupMessageChan(messageChan, messageEndChan)
+		return
+	}
+
+	configInstance, err := grokstat.LoadConfigFile(configPath)
+	if err != nil {
+		PrintError(messageChan, grokstat.ErrorLoadingConfig, jsonFlags)
+		CleanupMessageChan(messageChan, messageEndChan)
+		return
+	}
+
+	protColl := grokstat.LoadProtocols(configInstance.Protocols)
+
+	for protocolId, overrides := range jsonFlags.Overrides {
+		if !protColl.Override(protocolId, overrides) {
+			PrintError(messageChan, grokstat.InvalidProtocol, jsonFlags)
+			CleanupMessageChan(messageChan, messageEndChan)
+			return
+		}
+	}
+
+	if jsonFlags.Listen != "" {
+		if err := RunDaemon(jsonFlags, protColl, messageChan); err != nil {
+			PrintError(messageChan, err, jsonFlags)
+		}
+		CleanupMessageChan(messageChan, messageEndChan)
+		return
+	}
+
+	if showProtocols {
+		PrintProtocols(messageChan, protColl, jsonFlags)
+		CleanupMessageChan(messageChan, messageEndChan)
+		return
+	}
+
+	hosts := grokstat.MakeHostProtocolIdPairs(hostMap)
+
+	if len(hosts) == 0 {
+		PrintError(messageChan, grokstat.NoHosts, jsonFlags)
+		CleanupMessageChan(messageChan, messageEndChan)
+		return
+	}
+
+	if jsonFlags.WatchInterval > 0 {
+		if err := RunWatch(jsonFlags, protColl, hosts, messageChan); err != nil {
+			PrintError(messageChan, err, jsonFlags)
+		}
+		CleanupMessageChan(messageChan, messageEndChan)
+		return
+	}
+
+	var entryHandler func(grokstat.ServerEntry)
+	if jsonFlags.OutputFormat == OUTPUT_FORMAT_JSONL {
+		entryHandler = func(entry grokstat.ServerEntry) {
+			line, _ := FormJSONLine(entry)
+			messageChan <- grokstat.ConsoleMsg{Type: grokstat.MSG_MAJOR, Message: line}
+		}
+	}
+
+	queryTime := time.Now()
+	result, err := grokstat.Query(context.Background(), hosts, MakeQueryOptions(jsonFlags, protColl, messageChan, entryHandler))
+	if err == nil {
+		RecordHistoryFile(jsonFlags, result, queryTime, messageChan)
+	}
+
+	if jsonFlags.OutputFormat == OUTPUT_FORMAT_JSONL {
+		summary, _ := FormJSONLinesSummary(result, err)
+		messageChan <- grokstat.ConsoleMsg{Type: grokstat.MSG_MAJOR, Message: summary}
+	} else if err == nil && jsonFlags.OutputFormat == OUTPUT_FORMAT_XML {
+		PrintXMLResponse(messageChan, result.Servers, err, jsonFlags)
+	} else if err == nil {
+		PrintJsonResponse(messageChan, MakeQueryOutput(result), err, jsonFlags)
+	} else {
+		PrintError(messageChan, err, jsonFlags)
+		CleanupMessageChan(messageChan, messageEndChan)
+		return
+	}
+
+	CleanupMessageChan(messageChan, messageEndChan)
+}
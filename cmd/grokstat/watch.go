@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/grokstat/grokstat"
+)
+
+const (
+	WATCH_SERVER_UP          = "server-up"
+	WATCH_SERVER_DOWN        = "server-down"
+	WATCH_MAP_CHANGED        = "map-changed"
+	WATCH_PLAYER_JOINED      = "player-joined"
+	WATCH_PLAYER_LEFT        = "player-left"
+	WATCH_NUMCLIENTS_CHANGED = "numclients-changed"
+)
+
+// Change between two consecutive watch queries. Old and new values are set for the map and player count changes.
+type WatchEvent struct {
+	Type     string      `json:"type"`
+	Time     int64       `json:"time"`
+	Host     string      `json:"host"`
+	Protocol string      `json:"protocol"`
+	Player   string      `json:"player,omitempty"`
+	Old      interface{} `json:"old,omitempty"`
+	New      interface{} `json:"new,omitempty"`
+}
+
+func countPlayerNames(entry grokstat.ServerEntry) map[string]int {
+	names := map[string]int{}
+	for _, player := range entry.Players {
+		names[player.Name]++
+	}
+	return names
+}
+
+func diffPlayers(previous, current grokstat.ServerEntry, makeEvent func(string) WatchEvent) []WatchEvent {
+	events := []WatchEvent{}
+	previousNames := countPlayerNames(previous)
+	currentNames := countPlayerNames(current)
+
+	names := []string{}
+	for name := range previousNames {
+		names = append(names, name)
+	}
+	for name := range currentNames {
+		if _, exists := previousNames[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for i := currentNames[name]; i < previousNames[name]; i++ {
+			event := makeEvent(WATCH_PLAYER_LEFT)
+			event.Player = name
+			events = append(events, event)
+		}
+		for i := previousNames[name]; i < currentNames[name]; i++ {
+			event := makeEvent(WATCH_PLAYER_JOINED)
+			event.Player = name
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Compares the server entries of two consecutive queries by host. Without previous entries every server is reported as up or down.
+// Players are matched by name.
+func DiffServerEntries(previous, current map[string]grokstat.ServerEntry, now time.Time) []WatchEvent {
+	hosts := []string{}
+	for host := range previous {
+		hosts = append(hosts, host)
+	}
+	for host := range current {
+		if _, exists := previous[host]; !exists {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+
+	events := []WatchEvent{}
+	for _, host := range hosts {
+		previousEntry, wasKnown := previous[host]
+		currentEntry, isKnown := current[host]
+		wasUp := wasKnown && previousEntry.Error == nil
+		isUp := isKnown && currentEntry.Error == nil
+
+		protocolId := currentEntry.Protocol
+		if !isKnown {
+			protocolId = previousEntry.Protocol
+		}
+		makeEvent := func(eventType string) WatchEvent {
+			return WatchEvent{Type: eventType, Time: now.Unix(), Host: host, Protocol: protocolId}
+		}
+
+		switch {
+		case isUp && !wasUp:
+			events = append(events, makeEvent(WATCH_SERVER_UP))
+		case !isUp && (wasUp || !wasKnown):
+			events = append(events, makeEvent(WATCH_SERVER_DOWN))
+		case isUp && wasUp:
+			if previousEntry.Terrain != currentEntry.Terrain {
+				event := makeEvent(WATCH_MAP_CHANGED)
+				event.Old, event.New = previousEntry.Terrain, currentEntry.Terrain
+				events = append(events, event)
+			}
+			events = append(events, diffPlayers(previousEntry, currentEntry, makeEvent)...)
+			if previousEntry.NumClients != currentEntry.NumClients {
+				event := makeEvent(WATCH_NUMCLIENTS_CHANGED)
+				event.Old, event.New = previousEntry.NumClients, currentEntry.NumClients
+				events = append(events, event)
+			}
+		}
+	}
+	return events
+}
+
+// Queries the hosts at the interval and prints the changes as JSON lines until the process is interrupted.
+func RunWatch(flags InputData, protColl *grokstat.ProtocolCollection, hosts []grokstat.HostProtocolIdPair, messageChan chan<- grokstat.ConsoleMsg) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signalChan)
+	go func() {
+		select {
+		case <-signalChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	interval := time.Duration(flags.WatchInterval) * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var previous map[string]grokstat.ServerEntry
+	for {
+		opts := MakeQueryOptions(flags, protColl, messageChan, nil)
+		if opts.Timeout <= 0 || opts.Timeout > interval {
+			opts.Timeout = interval
+		}
+		queryTime := time.Now()
+		result, err := grokstat.Query(ctx, hosts, opts)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		RecordHistoryFile(flags, result, queryTime, messageChan)
+
+		current := map[string]grokstat.ServerEntry{}
+		for _, entry := range result.Servers {
+			current[entry.Host] = entry
+		}
+		for _, event := range DiffServerEntries(previous, current, time.Now()) {
+			jsonOut, _ := json.Marshal(event)
+			messageChan <- grokstat.ConsoleMsg{Type: grokstat.MSG_MAJOR, Message: string(jsonOut)}
+		}
+		previous = current
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grokstat/grokstat"
+)
+
+func TestDiffServerEntries(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	previous := map[string]grokstat.ServerEntry{
+		"127.0.0.1:27960": {Protocol: "q3s", Host: "127.0.0.1:27960", Status: 200, Terrain: "q3dm17", NumClients: 2, Players: []grokstat.PlayerEntry{{Name: "Visor"}, {Name: "Sarge"}}},
+		"127.0.0.2:27960": {Protocol: "q3s", Host: "127.0.0.2:27960", Status: 200},
+		"127.0.0.3:27960": {Protocol: "q3s", Host: "127.0.0.3:27960", Status: 503, Error: grokstat.ServerDown},
+	}
+	current := map[string]grokstat.ServerEntry{
+		"127.0.0.1:27960": {Protocol: "q3s", Host: "127.0.0.1:27960", Status: 200, Terrain: "q3dm6", NumClients: 3, Players: []grokstat.PlayerEntry{{Name: "Visor"}, {Name: "Doom"}, {Name: "Doom"}}},
+		"127.0.0.2:27960": {Protocol: "q3s", Host: "127.0.0.2:27960", Status: 503, Error: grokstat.ServerDown},
+		"127.0.0.3:27960": {Protocol: "q3s", Host: "127.0.0.3:27960", Status: 200},
+	}
+
+	makeEvent := func(eventType string, host string) WatchEvent {
+		return WatchEvent{Type: eventType, Time: now.Unix(), Host: host, Protocol: "q3s"}
+	}
+	mapChanged := makeEvent(WATCH_MAP_CHANGED, "127.0.0.1:27960")
+	mapChanged.Old, mapChanged.New = "q3dm17", "q3dm6"
+	doomJoined := makeEvent(WATCH_PLAYER_JOINED, "127.0.0.1:27960")
+	doomJoined.Player = "Doom"
+	sargeLeft := makeEvent(WATCH_PLAYER_LEFT, "127.0.0.1:27960")
+	sargeLeft.Player = "Sarge"
+	numClientsChanged := makeEvent(WATCH_NUMCLIENTS_CHANGED, "127.0.0.1:27960")
+	numClientsChanged.Old, numClientsChanged.New = int64(2), int64(3)
+
+	expectation := []WatchEvent{mapChanged, doomJoined, doomJoined, sargeLeft, numClientsChanged, makeEvent(WATCH_SERVER_DOWN, "127.0.0.2:27960"), makeEvent(WATCH_SERVER_UP, "127.0.0.3:27960")}
+	result := DiffServerEntries(previous, current, now)
+	if !reflect.DeepEqual(expectation, result) {
+		t.Errorf(grokstat.ErrorOut(expectation, result))
+	}
+
+	expectation = []WatchEvent{makeEvent(WATCH_SERVER_UP, "127.0.0.1:27960"), makeEvent(WATCH_SERVER_UP, "127.0.0.2:27960"), makeEvent(WATCH_SERVER_DOWN, "127.0.0.3:27960")}
+	result = DiffServerEntries(nil, previous, now)
+	if !reflect.DeepEqual(expectation, result) {
+		t.Errorf(grokstat.ErrorOut(expectation, result))
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/grokstat/grokstat"
+)
+
+type QStatXML struct {
+	XMLName xml.Name      `xml:"qstat"`
+	Error   string        `xml:"error,omitempty"`
+	Servers []QStatServer `xml:"server"`
+}
+
+type QStatServer struct {
+	Type     string `xml:"type,attr"`
+	Address  string `xml:"address,attr"`
+	Status   string `xml:"status,attr"`
+	Hostname string `xml:"hostname"`
+	*QStatServerInfo
+}
+
+// Server details, present only for the servers which are up.
+type QStatServerInfo struct {
+	Name       string        `xml:"name"`
+	GameType   string        `xml:"gametype"`
+	Map        string        `xml:"map"`
+	NumPlayers int64         `xml:"numplayers"`
+	MaxPlayers int64         `xml:"maxplayers"`
+	Ping       int64         `xml:"ping"`
+	Rules      []QStatRule   `xml:"rules>rule"`
+	Players    []QStatPlayer `xml:"players>player"`
+}
+
+type QStatRule struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type QStatPlayer struct {
+	Name  string `xml:"name"`
+	Score string `xml:"score,omitempty"`
+	Ping  int64  `xml:"ping"`
+	Time  string `xml:"time,omitempty"`
+}
+
+// Converts the entry status to the QStat server status.
+func QStatStatus(status int) string {
+	switch status {
+	case 200:
+		return "UP"
+	case 503:
+		return "TIMEOUT"
+	case 404:
+		return "HOSTNOTFOUND"
+	default:
+		return "ERROR"
+	}
+}
+
+// Returns the value of the first of the keys the player info has. Protocols name the same value differently.
+func playerInfo(player grokstat.PlayerEntry, keys ...string) string {
+	for _, key := range keys {
+		for k, v := range player.Info {
+			if strings.EqualFold(k, key) {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+func MakeQStatServer(entry grokstat.ServerEntry) QStatServer {
+	server := QStatServer{Type: strings.ToUpper(entry.Protocol), Address: entry.Host, Status: QStatStatus(entry.Status), Hostname: entry.Host}
+	if entry.Error != nil {
+		return server
+	}
+
+	info := &QStatServerInfo{Name: entry.Name, GameType: entry.GameType, Map: entry.Terrain, NumPlayers: entry.NumClients, MaxPlayers: entry.MaxClients, Ping: entry.Ping, Rules: []QStatRule{}, Players: []QStatPlayer{}}
+
+	ruleNames := make([]string, 0, len(entry.Rules))
+	for k := range entry.Rules {
+		ruleNames = append(ruleNames, k)
+	}
+	sort.Strings(ruleNames)
+	for _, k := range ruleNames {
+		info.Rules = append(info.Rules, QStatRule{Name: k, Value: entry.Rules[k]})
+	}
+
+	for _, player := range entry.Players {
+		info.Players = append(info.Players, QStatPlayer{Name: player.Name, Score: playerInfo(player, "score"), Ping: player.Ping, Time: playerInfo(player, "duration", "time")})
+	}
+
+	server.QStatServerInfo = info
+	return server
+}
+
+type entriesByHost []grokstat.ServerEntry
+
+func (s entriesByHost) Len() int           { return len(s) }
+func (s entriesByHost) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s entriesByHost) Less(i, j int) bool { return s[i].Host < s[j].Host }
+
+// FormXMLResponse creates a QStat-style XML document out of the server entries.
+var FormXMLResponse = func(entries []grokstat.ServerEntry, err error, flags InputData) (string, error) {
+	result := QStatXML{Servers: []QStatServer{}}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	sortedEntries := make([]grokstat.ServerEntry, len(entries))
+	copy(sortedEntries, entries)
+	sort.Sort(entriesByHost(sortedEntries))
+	for _, entry := range sortedEntries {
+		result.Servers = append(result.Servers, MakeQStatServer(entry))
+	}
+
+	xmlOut, xmlErr := xml.MarshalIndent(result, "", "\t")
+
+	if xmlErr != nil {
+		return fmt.Sprintf("%s<qstat><error>XML marshaller error.</error></qstat>", xml.Header), xmlErr
+	}
+
+	return xml.Header + string(xmlOut), nil
+}
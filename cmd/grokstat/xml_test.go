@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grokstat/grokstat"
+)
+
+func TestFormXMLResponse(t *testing.T) {
+	entries := []grokstat.ServerEntry{
+		{Protocol: "q3s", Host: "127.0.0.2:27960", Status: 503, Error: grokstat.ServerDown},
+		{Protocol: "q3s", Host: "127.0.0.1:27960", Status: 200, Name: "Test & Play", Terrain: "q3dm17", NumClients: 1, MaxClients: 8, Ping: 42, Rules: map[string]string{"sv_hostname": "Test & Play", "g_gametype": "0"}, Players: []grokstat.PlayerEntry{{Name: "Visor", Ping: 50, Info: map[string]string{"Score": "12"}}}},
+		{Protocol: "qws", Host: "127.0.0.0:27500", Status: 200, Name: "Grok QW", Players: []grokstat.PlayerEntry{{Name: "Grok Stat", Ping: 48, Info: map[string]string{"frags": "25", "time": "14"}}}},
+	}
+
+	result, err := FormXMLResponse(entries, nil, MakeInputData())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expectation := range []string{
+		`<?xml version="1.0" encoding="UTF-8"?>`,
+		`<server type="Q3S" address="127.0.0.1:27960" status="UP">`,
+		`<name>Test &amp; Play</name>`,
+		`<map>q3dm17</map>`,
+		`<numplayers>1</numplayers>`,
+		`<maxplayers>8</maxplayers>`,
+		`<ping>42</ping>`,
+		`<rule name="g_gametype">0</rule>`,
+		`<score>12</score>`,
+		`<time>14</time>`,
+		`<server type="Q3S" address="127.0.0.2:27960" status="TIMEOUT">`,
+	} {
+		if !strings.Contains(result, expectation) {
+			t.Errorf(grokstat.ErrorOut(expectation, result))
+		}
+	}
+
+	if strings.Index(result, "127.0.0.1:27960") > strings.Index(result, "127.0.0.2:27960") {
+		t.Errorf("Servers are not sorted by address:\n%s", result)
+	}
+	if strings.Contains(result[strings.Index(result, "127.0.0.2:27960"):], "<numplayers>") {
+		t.Errorf("Details present for the server which is down:\n%s", result)
+	}
+}
@@ -1,6 +1,19 @@
-package main
+package grokstat
+
+import "time"
 
 const (
-	VERSION            = "0.1"
-	DEFAULT_OUTPUT_LVL = MSG_MAJOR
+	VERSION                      = "0.1"
+	DEFAULT_OUTPUT_LVL           = MSG_MAJOR
+	DEFAULT_IDLE_TIMEOUT         = 5 * time.Second
+	DEFAULT_PROBE_INTERVAL       = 500 * time.Millisecond
+	DEFAULT_RETRIES              = 2
+	DEFAULT_RETRY_BACKOFF        = time.Second
+	RETRY_CHECK_INTERVAL         = 50 * time.Millisecond
+	DEFAULT_SEND_RATE            = 500
+	DEFAULT_MAX_IN_FLIGHT        = 1024
+	PACER_POLL_INTERVAL          = 10 * time.Millisecond
+	UDP_READ_BUFFER_SIZE         = 4 * 1024 * 1024
+	UDP_DRAIN_TIMEOUT            = time.Millisecond
+	DEFAULT_HISTORY_LOCK_TIMEOUT = time.Second
 )
@@ -0,0 +1,44 @@
+package grokstat
+
+import (
+	"context"
+	"sync"
+)
+
+// Engine keeps the UDP sockets open between queries, so that long-running programs do not open new ones for every query.
+// Queries run through the engine one at a time, so that the responses are not mixed up with those of another query. Concurrent queries need an engine each.
+type Engine struct {
+	sync.Mutex
+	sockets *UDPSockets
+}
+
+func MakeEngine(messageChan chan<- ConsoleMsg) (*Engine, error) {
+	sockets, err := ListenUDPSockets(messageChan)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{sockets: sockets}, nil
+}
+
+// Runs the query on the sockets of the engine, waiting for the running query to finish first.
+func (e *Engine) Query(ctx context.Context, hosts []HostProtocolIdPair, opts QueryOptions) (QueryResult, error) {
+	e.Lock()
+	defer e.Unlock()
+	if e.sockets == nil {
+		return QueryResult{Servers: []ServerEntry{}, Errors: map[string]error{}}, EngineClosed
+	}
+	opts.udpSockets = e.sockets
+	return Query(ctx, hosts, opts)
+}
+
+// Closes the sockets once the running query has finished.
+func (e *Engine) Close() error {
+	e.Lock()
+	defer e.Unlock()
+	if e.sockets == nil {
+		return nil
+	}
+	err := e.sockets.Close()
+	e.sockets = nil
+	return err
+}
@@ -0,0 +1,37 @@
+package grokstat
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEngineRepeated(t *testing.T) {
+	conn := startMumbleStub(t, 0)
+	defer conn.Close()
+
+	messageChan := make(chan ConsoleMsg, 100)
+	engine, err := MakeEngine(messageChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: conn.LocalAddr().String(), ProtocolId: "mumbles"}}
+	expectation := 200
+
+	for i := 0; i < 3; i++ {
+		result, err := engine.Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: 100 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Servers) != 1 || result.Servers[0].Status != expectation {
+			t.Errorf(ErrorOut(expectation, result.Servers))
+		}
+	}
+
+	engine.Close()
+	if _, err := engine.Query(context.Background(), hosts, QueryOptions{Protocols: protColl}); err != EngineClosed {
+		t.Errorf(ErrorOut(EngineClosed, err))
+	}
+}
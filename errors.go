@@ -1,6 +1,9 @@
-package main
+package grokstat
 
-import "errors"
+import (
+	"errors"
+	"net"
+)
 
 var (
 	OK = errors.New("OK.")
@@ -8,10 +11,19 @@ var (
 	NoConfig           = errors.New("No config file specified.")
 	ErrorLoadingConfig = errors.New("Error loading config file.")
 
-	IPv6NotSupported = errors.New("IPv6 is not supported yet.")
+	NoProtocol  = errors.New("Please specify the protocol.")
+	NoProtocols = errors.New("No protocols loaded.")
+	NoHosts     = errors.New("Please specify the hosts to query.")
+
+	InvalidOutputFormat = errors.New("Invalid output format.")
 
-	NoProtocol = errors.New("Please specify the protocol.")
-	NoHosts    = errors.New("Please specify the hosts to query.")
+	InvalidRequestMethod = errors.New("Invalid request method.")
+	InvalidRequestPath   = errors.New("Invalid request path.")
+	TooManyRequests      = errors.New("Too many requests.")
+	EngineClosed         = errors.New("Engine closed.")
+
+	InvalidHistoryGroup = errors.New("Invalid history grouping, use protocol or map.")
+	NoHistoryPath       = errors.New("Please specify the history path.")
 
 	InvalidProtocol = errors.New("Invalid protocol specified.")
 	InvalidMasterOf = errors.New("Invalid query part attached to master protocol.")
@@ -23,6 +35,8 @@ var (
 	InvalidResponseHeader    = errors.New("Invalid response header.")
 	InvalidResponseLength    = errors.New("Invalid response length.")
 	InvalidResponseChallenge = errors.New("Invalid response challenge.")
+	InvalidChecksum          = errors.New("Invalid checksum.")
+	InvalidVarInt            = errors.New("Invalid VarInt.")
 
 	InvalidServerEntryInMasterResponse = errors.New("Invalid server entry in the master server response.")
 
@@ -40,3 +54,22 @@ var (
 
 	CompError = errors.New("Mismatch.")
 )
+
+// Returns the status code reported for the host failing with the error.
+func ErrorStatus(err error) int {
+	switch err {
+	case nil:
+		return 200
+	case NoProtocol, InvalidProtocol, InvalidMasterOf:
+		return 400
+	case ServerDown:
+		return 503
+	}
+	if _, isDNSError := err.(*net.DNSError); isDNSError {
+		return 404
+	}
+	if _, isAddrError := err.(*net.AddrError); isAddrError {
+		return 404
+	}
+	return 502
+}
@@ -0,0 +1,173 @@
+package grokstat
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	HISTORY_GROUP_BY_PROTOCOL = "protocol"
+	HISTORY_GROUP_BY_MAP      = "map"
+)
+
+var historyBucket = []byte("servers")
+
+// History stores the server entry snapshots in a BoltDB file. Snapshots are kept in a bucket per host, keyed by the timestamp.
+type History struct {
+	db *bolt.DB
+}
+
+// Server entry recorded at the specified time.
+type HistorySnapshot struct {
+	Time  time.Time   `json:"time"`
+	Entry ServerEntry `json:"entry"`
+}
+
+// Statistics of the snapshots sharing the protocol or map.
+type HistoryAggregate struct {
+	Key        string  `json:"key"`
+	Samples    int     `json:"samples"`
+	Uptime     float64 `json:"uptime"`
+	AvgPlayers float64 `json:"avg-players"`
+	MaxPlayers int64   `json:"max-players"`
+}
+
+// Opens the history file, creating it if necessary. Fails if the file is held by another process for longer than DEFAULT_HISTORY_LOCK_TIMEOUT.
+func OpenHistory(path string) (*History, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: DEFAULT_HISTORY_LOCK_TIMEOUT})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, bErr := tx.CreateBucketIfNotExists(historyBucket)
+		return bErr
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &History{db: db}, nil
+}
+
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+func historyKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+// Records the server entries as taken at the specified time.
+func (h *History) Record(entries []ServerEntry, t time.Time) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		servers := tx.Bucket(historyBucket)
+		for _, entry := range entries {
+			hostBucket, err := servers.CreateBucketIfNotExists([]byte(entry.Host))
+			if err != nil {
+				return err
+			}
+			value, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err = hostBucket.Put(historyKey(t), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func forEachSnapshot(hostBucket *bolt.Bucket, from, to time.Time, f func(HistorySnapshot) error) error {
+	cursor := hostBucket.Cursor()
+	toKey := historyKey(to)
+	for k, v := cursor.Seek(historyKey(from)); k != nil && string(k) <= string(toKey); k, v = cursor.Next() {
+		snapshot := HistorySnapshot{Time: time.Unix(0, int64(binary.BigEndian.Uint64(k)))}
+		if err := json.Unmarshal(v, &snapshot.Entry); err != nil {
+			return err
+		}
+		if err := f(snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the snapshots of the host taken within the time range, oldest first.
+func (h *History) Series(host string, from, to time.Time) ([]HistorySnapshot, error) {
+	snapshots := []HistorySnapshot{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		hostBucket := tx.Bucket(historyBucket).Bucket([]byte(host))
+		if hostBucket == nil {
+			return nil
+		}
+		return forEachSnapshot(hostBucket, from, to, func(snapshot HistorySnapshot) error {
+			snapshots = append(snapshots, snapshot)
+			return nil
+		})
+	})
+	return snapshots, err
+}
+
+// Aggregates the snapshots of all hosts taken within the time range by protocol or map. Player statistics only take the snapshots of servers which were up into account.
+func (h *History) Aggregate(groupBy string, from, to time.Time) ([]HistoryAggregate, error) {
+	if groupBy != HISTORY_GROUP_BY_PROTOCOL && groupBy != HISTORY_GROUP_BY_MAP {
+		return nil, InvalidHistoryGroup
+	}
+
+	aggregates := map[string]*HistoryAggregate{}
+	upSamples := map[string]int{}
+	playerSums := map[string]int64{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(historyBucket).ForEach(func(host []byte, _ []byte) error {
+			hostBucket := tx.Bucket(historyBucket).Bucket(host)
+			if hostBucket == nil {
+				return nil
+			}
+			return forEachSnapshot(hostBucket, from, to, func(snapshot HistorySnapshot) error {
+				entry := snapshot.Entry
+				key := entry.Protocol
+				if groupBy == HISTORY_GROUP_BY_MAP {
+					if entry.Status != 200 {
+						return nil
+					}
+					key = entry.Terrain
+				}
+				aggregate, exists := aggregates[key]
+				if !exists {
+					aggregate = &HistoryAggregate{Key: key}
+					aggregates[key] = aggregate
+				}
+				aggregate.Samples++
+				if entry.Status == 200 {
+					upSamples[key]++
+					playerSums[key] += entry.NumClients
+					if entry.NumClients > aggregate.MaxPlayers {
+						aggregate.MaxPlayers = entry.NumClients
+					}
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []HistoryAggregate{}
+	for key, aggregate := range aggregates {
+		aggregate.Uptime = float64(upSamples[key]) / float64(aggregate.Samples)
+		if upSamples[key] > 0 {
+			aggregate.AvgPlayers = float64(playerSums[key]) / float64(upSamples[key])
+		}
+		result = append(result, *aggregate)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, nil
+}
@@ -0,0 +1,65 @@
+package grokstat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	dir, dErr := ioutil.TempDir("", "grokstat")
+	if dErr != nil {
+		t.Fatal(dErr)
+	}
+	defer os.RemoveAll(dir)
+
+	history, err := OpenHistory(filepath.Join(dir, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	start := time.Unix(1500000000, 0)
+	snapshots := [][]ServerEntry{
+		{{Protocol: "q3s", Host: "127.0.0.1:27960", Status: 200, Terrain: "q3dm17", NumClients: 2}, {Protocol: "a2s", Host: "127.0.0.1:27015", Status: 200, Terrain: "de_dust2", NumClients: 10}},
+		{{Protocol: "q3s", Host: "127.0.0.1:27960", Status: 200, Terrain: "q3dm17", NumClients: 4}, {Protocol: "a2s", Host: "127.0.0.1:27015", Status: 503}},
+		{{Protocol: "q3s", Host: "127.0.0.1:27960", Status: 200, Terrain: "q3dm6", NumClients: 1}},
+	}
+	for i, entries := range snapshots {
+		if err = history.Record(entries, start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	series, err := history.Series("127.0.0.1:27960", start.Add(time.Minute), start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seriesExpectation := []int64{4, 1}
+	seriesResult := []int64{}
+	for _, snapshot := range series {
+		seriesResult = append(seriesResult, snapshot.Entry.NumClients)
+	}
+	if !reflect.DeepEqual(seriesExpectation, seriesResult) || !series[0].Time.Equal(start.Add(time.Minute)) {
+		t.Errorf(ErrorOut(seriesExpectation, series))
+	}
+
+	protocolExpectation := []HistoryAggregate{{Key: "a2s", Samples: 2, Uptime: 0.5, AvgPlayers: 10, MaxPlayers: 10}, {Key: "q3s", Samples: 3, Uptime: 1, AvgPlayers: 7.0 / 3, MaxPlayers: 4}}
+	protocolResult, err := history.Aggregate(HISTORY_GROUP_BY_PROTOCOL, start, start.Add(time.Hour))
+	if err != nil || !reflect.DeepEqual(protocolExpectation, protocolResult) {
+		t.Errorf(ErrorOut(protocolExpectation, protocolResult))
+	}
+
+	mapExpectation := []HistoryAggregate{{Key: "de_dust2", Samples: 1, Uptime: 1, AvgPlayers: 10, MaxPlayers: 10}, {Key: "q3dm17", Samples: 2, Uptime: 1, AvgPlayers: 3, MaxPlayers: 4}, {Key: "q3dm6", Samples: 1, Uptime: 1, AvgPlayers: 1, MaxPlayers: 1}}
+	mapResult, err := history.Aggregate(HISTORY_GROUP_BY_MAP, start, start.Add(time.Hour))
+	if err != nil || !reflect.DeepEqual(mapExpectation, mapResult) {
+		t.Errorf(ErrorOut(mapExpectation, mapResult))
+	}
+
+	if _, err = history.Aggregate("host", start, start.Add(time.Hour)); err != InvalidHistoryGroup {
+		t.Errorf(ErrorOut(InvalidHistoryGroup, err))
+	}
+}
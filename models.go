@@ -1,4 +1,10 @@
-package main
+package grokstat
+
+import (
+	"bufio"
+	"net"
+	"time"
+)
 
 type ConsoleMsg struct {
 	Type    int
@@ -13,6 +19,14 @@ type Packet struct {
 	Data       []byte
 	Ping       int64
 	Timestamp  int64
+	// Time the packet was read from the network.
+	ReceiveTime time.Time
+	// Time elapsed between sending the matching request and receiving the packet.
+	RoundTripTime time.Duration
+	// Number of times the request has been re-sent.
+	Attempt int
+	// Host name the request was made for, before it was resolved to RemoteAddr.
+	Hostname string
 }
 
 type PacketType int
@@ -51,14 +65,58 @@ func (v PacketType) IsIP6() bool {
 	}
 }
 
+// Returns the network name to be used with the net package.
+func (v PacketType) Network() string {
+	switch v {
+	case TYPE_TCP4:
+		return "tcp4"
+	case TYPE_TCP6:
+		return "tcp6"
+	case TYPE_UDP4:
+		return "udp4"
+	case TYPE_UDP6:
+		return "udp6"
+	case TYPE_TCP:
+		return "tcp"
+	default:
+		return "udp"
+	}
+}
+
+// Returns the packet type for the transport ("tcp" or "udp") and address family of the remote address.
+func MakePacketType(transport string, remoteAddr string) PacketType {
+	var isIP6 bool
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err == nil {
+		ip := net.ParseIP(host)
+		isIP6 = ip != nil && ip.To4() == nil
+	}
+
+	if transport == "tcp" {
+		if isIP6 {
+			return TYPE_TCP6
+		}
+		return TYPE_TCP4
+	}
+	if isIP6 {
+		return TYPE_UDP6
+	}
+	return TYPE_UDP4
+}
+
 type ProtocolEntryInfo map[string]string
 
+// PrepareQueryFunc, if set, is called on the copy of the entry made for each query. It sets up the functions which keep state between the responses, such as split packet fragments.
 type ProtocolEntryBase struct {
-	MakePayloadFunc func(Packet, ProtocolEntryInfo) Packet                                                                       `json:"-"`
-	RequestPackets  []RequestPacket                                                                                              `json:"-"`
-	HandlerFunc     func(Packet, *ProtocolCollection, chan<- ConsoleMsg, chan<- HostProtocolIdPair, chan<- ServerEntry) []Packet `json:"-"`
-	HttpProtocol    string                                                                                                       `json:"http_protocol"`
-	ResponseType    string                                                                                                       `json:"response_type"`
+	MakePayloadFunc   func(Packet, ProtocolEntryInfo) Packet                                                                       `json:"-"`
+	RequestPackets    []RequestPacket                                                                                              `json:"-"`
+	HandlerFunc       func(Packet, *ProtocolCollection, chan<- ConsoleMsg, chan<- HostProtocolIdPair, chan<- ServerEntry) []Packet `json:"-"`
+	PrepareQueryFunc  func(*ProtocolEntryBase)                                                                                     `json:"-"`
+	SplitFunc         bufio.SplitFunc                                                                                              `json:"-"`
+	ResponseIdFunc    func(Packet, ProtocolEntryInfo) string                                                                       `json:"-"`
+	ResponseMatchFunc func(Packet, ProtocolEntryInfo) bool                                                                         `json:"-"`
+	HttpProtocol      string                                                                                                       `json:"http_protocol"`
+	ResponseType      string                                                                                                       `json:"response_type"`
 }
 
 type RequestPacket struct {
@@ -76,6 +134,8 @@ type ProtocolEntry struct {
 type HostProtocolIdPair struct {
 	RemoteAddr string
 	ProtocolId string
+	// Host name as given, if RemoteAddr is its resolved address.
+	Hostname string
 }
 
 func MakeProtocolEntry(entryTemplate ProtocolEntry) ProtocolEntry {
@@ -100,25 +160,47 @@ var MakePlayerEntry = func() PlayerEntry {
 }
 
 type ServerEntry struct {
-	Protocol   string            `json:"protocol"`
-	Status     int               `json:"status"`
-	Error      error             `json:"-"`
-	Message    string            `json:"message"`
-	Host       string            `json:"host"`
-	Name       string            `json:"name"`
-	NeedPass   bool              `json:"need-pass"`
-	ModName    string            `json:"modname"`
-	GameType   string            `json:"gametype"`
-	Terrain    string            `json:"terrain"`
-	NumClients int64             `json:"numclients"`
-	MaxClients int64             `json:"maxclients"`
-	NumBots    int64             `json:"numbots"`
-	Secure     bool              `json:"secure"`
-	Ping       int64             `json:"ping"`
-	Players    []PlayerEntry     `json:"players"`
-	Rules      map[string]string `json:"rules"`
+	Protocol          string            `json:"protocol"`
+	Status            int               `json:"status"`
+	Error             error             `json:"-"`
+	Message           string            `json:"message"`
+	Host              string            `json:"host"`
+	Name              string            `json:"name"`
+	NeedPass          bool              `json:"need-pass"`
+	ModName           string            `json:"modname"`
+	GameType          string            `json:"gametype"`
+	Terrain           string            `json:"terrain"`
+	NumClients        int64             `json:"numclients"`
+	MaxClients        int64             `json:"maxclients"`
+	NumBots           int64             `json:"numbots"`
+	Secure            bool              `json:"secure"`
+	Ping              int64             `json:"ping"`
+	PingStats         *PingStats        `json:"ping-stats,omitempty"`
+	DetectedProtocols []string          `json:"detected-protocols,omitempty"`
+	Players           []PlayerEntry     `json:"players"`
+	Rules             map[string]string `json:"rules"`
+}
+
+// Round-trip time statistics in milliseconds.
+type PingStats struct {
+	Samples int     `json:"samples"`
+	Min     float64 `json:"min"`
+	Avg     float64 `json:"avg"`
+	Max     float64 `json:"max"`
+	Jitter  float64 `json:"jitter"`
 }
 
 var MakeServerEntry = func() ServerEntry {
 	return ServerEntry{Players: []PlayerEntry{}, Rules: map[string]string{}}
 }
+
+// Makes the entry describing the host which could not be queried.
+var MakeErrorServerEntry = func(host string, protocolId string, err error) ServerEntry {
+	entry := MakeServerEntry()
+	entry.Host = host
+	entry.Protocol = protocolId
+	entry.Status = ErrorStatus(err)
+	entry.Error = err
+	entry.Message = err.Error()
+	return entry
+}
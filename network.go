@@ -1,144 +1,607 @@
-package main
+package grokstat
 
 import (
+	"bufio"
 	"fmt"
+	"math"
 	"net"
+	"sync"
 	"time"
 )
 
-func receiveHandler(packet Packet, sendRequestChan chan<- Packet, parseHandler func(Packet) []Packet) {
+func receiveHandler(endChan <-chan struct{}, packet Packet, sendRequestChan chan<- Packet, parseHandler func(Packet) []Packet) {
 	sendPackets := parseHandler(packet)
 
 	for _, sendPacket := range sendPackets {
-		sendRequestChan <- sendPacket
+		select {
+		case sendRequestChan <- sendPacket:
+		case <-endChan:
+			return
+		}
 	}
 }
 
-func receiveHandlerLoop(endChan chan struct{}, receiveChan chan Packet, sendRequestChan chan<- Packet, receiveHandler func(Packet, chan<- Packet, func(Packet) []Packet), parseHandler func(Packet) []Packet, awakeChan chan<- struct{}) {
+// Hands the received packets over to the handlers. Returns once the end channel is closed and all handlers have finished.
+func receiveHandlerLoop(endChan <-chan struct{}, receiveChan chan Packet, sendRequestChan chan<- Packet, receiveHandler func(<-chan struct{}, Packet, chan<- Packet, func(Packet) []Packet), parseHandler func(Packet) []Packet, tracker *RequestTracker, identifyHandler func(Packet) string, awakeChan chan<- struct{}) {
+	var handlers sync.WaitGroup
+	defer handlers.Wait()
 	for {
 		select {
 		case dataAvailable := <-receiveChan:
-			awakeChan <- struct{}{}
-			go receiveHandler(dataAvailable, sendRequestChan, parseHandler)
+			wake(awakeChan)
+			packet, request, matched := trackResponse(tracker, identifyHandler, dataAvailable)
+			handlers.Add(1)
+			go func() {
+				defer handlers.Done()
+				receiveHandler(endChan, packet, sendRequestChan, parseHandler)
+				if matched {
+					tracker.Handled(request)
+				}
+			}()
 		case <-endChan:
 			return
 		}
 	}
 }
 
-func keepAliveLoop(awakeChan chan struct{}, timeOut time.Duration, endChans ...chan struct{}) {
-	<-awakeChan
+// Signals activity to the keep-alive loop without blocking.
+func wake(awakeChan chan<- struct{}) {
+	select {
+	case awakeChan <- struct{}{}:
+	default:
+	}
+}
+
+// Stops the servers once no traffic has been seen for the timeout duration or the cancel channel is closed.
+func keepAliveLoop(awakeChan chan struct{}, timeOut time.Duration, cancelChan <-chan struct{}, endChans ...chan struct{}) {
+	defer func() {
+		for _, endChannel := range endChans {
+			endChannel <- struct{}{}
+		}
+	}()
+	select {
+	case <-awakeChan:
+	case <-cancelChan:
+		return
+	}
 	for {
+		var timeOutChan <-chan time.Time
 		if timeOut > 0 {
-			select {
-			case <-awakeChan:
-			case <-time.After(timeOut):
-				for _, endChannel := range endChans {
-					endChannel <- struct{}{}
-				}
-				return
-			}
-		} else {
-			<-awakeChan
+			timeOutChan = time.After(timeOut)
+		}
+		select {
+		case <-awakeChan:
+		case <-timeOutChan:
+			return
+		case <-cancelChan:
+			return
 		}
 	}
 }
 
-func readUDP(conn *net.UDPConn) (Packet, error) {
-	bufsize := 2048
+func readUDP(conn *net.UDPConn, packetType PacketType) (Packet, error) {
+	bufsize := 65535
 	buf := make([]byte, bufsize)
 
 	n, addr, err := conn.ReadFromUDP(buf)
 	if err != nil {
 		return Packet{}, err
 	}
+	receiveTime := time.Now()
 
-	return Packet{Data: buf[:n], Timestamp: time.Now().Unix(), RemoteAddr: addr.String()}, nil
+	return Packet{Data: buf[:n], Type: packetType, Timestamp: receiveTime.Unix(), ReceiveTime: receiveTime, RemoteAddr: addr.String()}, nil
 }
 
-func writeUDP(conn *net.UDPConn, packet Packet) {
-	remoteIpUdp, rErr := net.ResolveUDPAddr("udp4", packet.RemoteAddr)
-	CheckError(rErr)
+func writeUDP(conn4, conn6 *net.UDPConn, packet Packet, tracker *RequestTracker, messageChan chan<- ConsoleMsg) {
+	remoteIpUdp, rErr := net.ResolveUDPAddr(packet.Type.Network(), packet.RemoteAddr)
+	if rErr != nil {
+		tracker.Dropped(packet)
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("Error resolving %s - %s", packet.RemoteAddr, rErr.Error())}
+		return
+	}
+
+	conn := conn4
+	if remoteIpUdp.IP.To4() == nil {
+		conn = conn6
+	}
+	if conn == nil {
+		tracker.Dropped(packet)
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("No UDP socket available for %s", packet.RemoteAddr)}
+		return
+	}
+	tracker.Sent(packet, time.Now())
 	conn.WriteToUDP(packet.Data, remoteIpUdp)
 }
 
-func udpReceiveLoop(endChan <-chan struct{}, conn *net.UDPConn, messageChan chan<- ConsoleMsg, receiveChan chan Packet, awakeChan chan struct{}) {
+// Reads the packets until the end channel is closed. The connection has to be closed as well to interrupt the pending read.
+func udpReceiveLoop(endChan <-chan struct{}, conn *net.UDPConn, packetType PacketType, messageChan chan<- ConsoleMsg, receiveChan chan Packet, awakeChan chan struct{}) {
 	for {
+		packet, err := readUDP(conn, packetType)
 		select {
 		case <-endChan:
 			return
 		default:
-			packet, err := readUDP(conn)
-			awakeChan <- struct{}{}
-			if err == nil {
-				messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("Read %d bytes from %s", len(packet.Data), packet.RemoteAddr)}
-				receiveChan <- packet
-			}
+		}
+		if err != nil {
+			continue
+		}
+		wake(awakeChan)
+		messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("Read %d bytes from %s", len(packet.Data), packet.RemoteAddr)}
+		select {
+		case receiveChan <- packet:
+		case <-endChan:
+			return
 		}
 	}
-
 }
 
-func udpSendLoop(endChan <-chan struct{}, conn *net.UDPConn, messageChan chan<- ConsoleMsg, sendChan chan Packet, awakeChan chan struct{}) {
+func udpSendLoop(endChan <-chan struct{}, conn4, conn6 *net.UDPConn, tracker *RequestTracker, messageChan chan<- ConsoleMsg, sendChan chan Packet, awakeChan chan struct{}) {
 	for {
 		select {
 		case dataSendPayload := <-sendChan:
 			messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("Writing %d bytes to %s", len(dataSendPayload.Data), dataSendPayload.RemoteAddr)}
-			awakeChan <- struct{}{}
-			go writeUDP(conn, dataSendPayload)
+			wake(awakeChan)
+			writeUDP(conn4, conn6, dataSendPayload, tracker, messageChan)
 		case <-endChan:
 			return
 		}
 	}
 }
 
-func AsyncUDPServer(endChan <-chan struct{}, initChan, doneChan chan<- struct{}, messageChan chan<- ConsoleMsg, sendChan, receiveChan chan Packet, parseHandler func(Packet) []Packet, timeOut time.Duration, awakeChan chan struct{}) {
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{
+// UDP sockets which outlive the network server, so that the servers run one after another can share them. The IPv6 socket is nil if IPv6 is unavailable.
+type UDPSockets struct {
+	conn4 *net.UDPConn
+	conn6 *net.UDPConn
+}
+
+// Opens the IPv4 and, if available, the IPv6 UDP socket.
+func ListenUDPSockets(messageChan chan<- ConsoleMsg) (*UDPSockets, error) {
+	conn4, err := net.ListenUDP("udp4", &net.UDPAddr{
 		Port: 0,
 		IP:   net.IPv4zero,
 	})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	defer conn.Close()
-	messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("Starting UDP server at %s", conn.LocalAddr().String())}
+	conn4.SetReadBuffer(UDP_READ_BUFFER_SIZE)
+	messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("Starting UDP server at %s", conn4.LocalAddr().String())}
+
+	conn6, err := net.ListenUDP("udp6", &net.UDPAddr{
+		Port: 0,
+		IP:   net.IPv6unspecified,
+	})
+	if err != nil {
+		conn6 = nil
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("IPv6 UDP server unavailable - %s", err.Error())}
+	} else {
+		conn6.SetReadBuffer(UDP_READ_BUFFER_SIZE)
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("Starting UDP server at %s", conn6.LocalAddr().String())}
+	}
+	return &UDPSockets{conn4: conn4, conn6: conn6}, nil
+}
+
+func (s *UDPSockets) conns() []*net.UDPConn {
+	if s.conn6 == nil {
+		return []*net.UDPConn{s.conn4}
+	}
+	return []*net.UDPConn{s.conn4, s.conn6}
+}
+
+// Discards the packets received since the last server run, such as late responses to its requests.
+func (s *UDPSockets) drain() {
+	buf := make([]byte, 65535)
+	for _, conn := range s.conns() {
+		conn.SetReadDeadline(time.Now().Add(UDP_DRAIN_TIMEOUT))
+		for {
+			if _, _, err := conn.ReadFromUDP(buf); err != nil {
+				break
+			}
+		}
+		conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// Interrupts the pending reads by setting a read deadline in the past. The deadline is cleared by resume.
+func (s *UDPSockets) interrupt() {
+	for _, conn := range s.conns() {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (s *UDPSockets) resume() {
+	for _, conn := range s.conns() {
+		conn.SetReadDeadline(time.Time{})
+	}
+}
+
+func (s *UDPSockets) Close() error {
+	var err error
+	for _, conn := range s.conns() {
+		if cErr := conn.Close(); cErr != nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+// Runs the UDP server on the sockets given, or on new sockets closed once the server stops if there are none.
+func AsyncUDPServer(endChan <-chan struct{}, initChan, doneChan chan<- struct{}, messageChan chan<- ConsoleMsg, sendChan, receiveChan chan Packet, tracker *RequestTracker, timeOut time.Duration, awakeChan chan struct{}, sockets *UDPSockets) {
+	ownSockets := sockets == nil
+	if ownSockets {
+		var err error
+		sockets, err = ListenUDPSockets(messageChan)
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		sockets.drain()
+	}
+	conn4, conn6 := sockets.conn4, sockets.conn6
 
-	endReceive := make(chan struct{}, 1)
-	endWrite := make(chan struct{}, 1)
+	endLoops := make(chan struct{})
+	var loops sync.WaitGroup
 
-	go udpReceiveLoop(endReceive, conn, messageChan, receiveChan, awakeChan)
-	go udpSendLoop(endWrite, conn, messageChan, sendChan, awakeChan)
+	startLoop := func(loop func()) {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			loop()
+		}()
+	}
+
+	startLoop(func() { udpReceiveLoop(endLoops, conn4, TYPE_UDP4, messageChan, receiveChan, awakeChan) })
+	if conn6 != nil {
+		startLoop(func() { udpReceiveLoop(endLoops, conn6, TYPE_UDP6, messageChan, receiveChan, awakeChan) })
+	}
+	startLoop(func() { udpSendLoop(endLoops, conn4, conn6, tracker, messageChan, sendChan, awakeChan) })
 
 	initChan <- struct{}{}
-	messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("Started UDP server at %s", conn.LocalAddr().String())}
+	messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("Started UDP server at %s", conn4.LocalAddr().String())}
 	<-endChan
-	endWrite <- struct{}{}
-	messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("Stopped UDP send loop.")}
-	endReceive <- struct{}{}
-	messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("Stopped UDP capture loop.")}
+	close(endLoops)
+	if ownSockets {
+		sockets.Close()
+		loops.Wait()
+	} else {
+		sockets.interrupt()
+		loops.Wait()
+		sockets.resume()
+	}
+	messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("Stopped UDP send and capture loops.")}
 	messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("UDP server stopped.")}
 	doneChan <- struct{}{}
 }
 
-func AsyncTCPServer(endChan <-chan struct{}, initChan, doneChan chan<- struct{}, messageChan chan<- ConsoleMsg, sendChan, receiveChan chan Packet, parseHandler func(Packet) []Packet, timeOut time.Duration, awakeChan chan struct{}) {
+// SplitWholeStream is the default TCP framing - everything received until the remote side closes the connection or goes silent is delivered as a single packet.
+func SplitWholeStream(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+type deadlineReader struct {
+	conn    net.Conn
+	timeOut time.Duration
+}
+
+func (r deadlineReader) Read(p []byte) (int, error) {
+	if r.timeOut > 0 {
+		r.conn.SetReadDeadline(time.Now().Add(r.timeOut))
+	}
+	return r.conn.Read(p)
+}
+
+type tcpConnEntry struct {
+	sync.Mutex
+	conn net.Conn
+}
+
+// Connections of the TCP client. Once closed, no new connections are handed out and the goroutines using them can be waited for.
+type tcpConnCollection struct {
+	sync.Mutex
+	data     map[string]*tcpConnEntry
+	closed   chan struct{}
+	routines sync.WaitGroup
+}
+
+// Returns the connection entry for the address, nil if the collection has been closed.
+func (c *tcpConnCollection) Acquire(k string) *tcpConnEntry {
+	c.Lock()
+	defer c.Unlock()
+	select {
+	case <-c.closed:
+		return nil
+	default:
+	}
+	v, exists := c.data[k]
+	if !exists {
+		v = &tcpConnEntry{}
+		c.data[k] = v
+	}
+	return v
+}
+
+func (c *tcpConnCollection) Release(k string, v *tcpConnEntry) {
+	c.Lock()
+	defer c.Unlock()
+	if c.data[k] == v {
+		delete(c.data, k)
+	}
+}
+
+func (c *tcpConnCollection) CloseAll() {
+	c.Lock()
+	close(c.closed)
+	entries := c.data
+	c.data = map[string]*tcpConnEntry{}
+	c.Unlock()
+
+	for _, v := range entries {
+		v.Lock()
+		if v.conn != nil {
+			v.conn.Close()
+		}
+		v.Unlock()
+	}
+}
+
+// Runs the function in a goroutine which is waited for by Wait.
+func (c *tcpConnCollection) Go(f func()) {
+	c.routines.Add(1)
+	go func() {
+		defer c.routines.Done()
+		f()
+	}()
+}
+
+func (c *tcpConnCollection) Wait() {
+	c.routines.Wait()
+}
+
+func MakeTCPConnCollection() *tcpConnCollection {
+	return &tcpConnCollection{data: map[string]*tcpConnEntry{}, closed: make(chan struct{})}
+}
+
+func tcpReceiveLoop(conns *tcpConnCollection, connEntry *tcpConnEntry, conn net.Conn, requestPacket Packet, splitFunc bufio.SplitFunc, messageChan chan<- ConsoleMsg, receiveChan chan Packet, timeOut time.Duration, awakeChan chan struct{}) {
+	defer conns.Release(requestPacket.RemoteAddr, connEntry)
+	defer conn.Close()
+
+	if splitFunc == nil {
+		splitFunc = SplitWholeStream
+	}
+
+	scanner := bufio.NewScanner(deadlineReader{conn: conn, timeOut: timeOut})
+	scanner.Buffer(make([]byte, 4096), 16777215)
+	scanner.Split(splitFunc)
+	for scanner.Scan() {
+		receiveTime := time.Now()
+		data := make([]byte, len(scanner.Bytes()))
+		copy(data, scanner.Bytes())
+
+		wake(awakeChan)
+		messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("Read %d bytes from %s", len(data), requestPacket.RemoteAddr)}
+		select {
+		case receiveChan <- Packet{Data: data, Type: requestPacket.Type, Timestamp: receiveTime.Unix(), ReceiveTime: receiveTime, RemoteAddr: requestPacket.RemoteAddr, ProtocolId: requestPacket.ProtocolId}:
+		case <-conns.closed:
+			return
+		}
+	}
+	messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("Closed TCP connection to %s", requestPacket.RemoteAddr)}
+}
+
+func writeTCP(conns *tcpConnCollection, packet Packet, splitFunc bufio.SplitFunc, tracker *RequestTracker, messageChan chan<- ConsoleMsg, receiveChan chan Packet, timeOut time.Duration, awakeChan chan struct{}) {
+	connEntry := conns.Acquire(packet.RemoteAddr)
+	if connEntry == nil {
+		tracker.Dropped(packet)
+		return
+	}
+	connEntry.Lock()
+	defer connEntry.Unlock()
+
+	if connEntry.conn == nil {
+		conn, err := net.DialTimeout(packet.Type.Network(), packet.RemoteAddr, timeOut)
+		if err != nil {
+			conns.Release(packet.RemoteAddr, connEntry)
+			tracker.Dropped(packet)
+			messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("Error connecting to %s - %s", packet.RemoteAddr, err.Error())}
+			return
+		}
+		connEntry.conn = conn
+		conns.Go(func() {
+			tcpReceiveLoop(conns, connEntry, conn, packet, splitFunc, messageChan, receiveChan, timeOut, awakeChan)
+		})
+	}
+
+	if timeOut > 0 {
+		connEntry.conn.SetWriteDeadline(time.Now().Add(timeOut))
+	}
+	tracker.Sent(packet, time.Now())
+	_, err := connEntry.conn.Write(packet.Data)
+	if err != nil {
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("Error writing to %s - %s", packet.RemoteAddr, err.Error())}
+	}
+}
+
+func tcpSendLoop(endChan <-chan struct{}, conns *tcpConnCollection, tracker *RequestTracker, messageChan chan<- ConsoleMsg, sendChan chan Packet, receiveChan chan Packet, splitHandler func(Packet) bufio.SplitFunc, timeOut time.Duration, awakeChan chan struct{}) {
+	for {
+		select {
+		case dataSendPayload := <-sendChan:
+			messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("Writing %d bytes to %s", len(dataSendPayload.Data), dataSendPayload.RemoteAddr)}
+			wake(awakeChan)
+			packet := dataSendPayload
+			conns.Go(func() {
+				writeTCP(conns, packet, splitHandler(packet), tracker, messageChan, receiveChan, timeOut, awakeChan)
+			})
+		case <-endChan:
+			return
+		}
+	}
+}
+
+func AsyncTCPServer(endChan <-chan struct{}, initChan, doneChan chan<- struct{}, messageChan chan<- ConsoleMsg, sendChan, receiveChan chan Packet, splitHandler func(Packet) bufio.SplitFunc, tracker *RequestTracker, timeOut time.Duration, awakeChan chan struct{}) {
+	conns := MakeTCPConnCollection()
+
+	endWrite := make(chan struct{})
+	sendLoopDone := make(chan struct{})
+
+	go func() {
+		defer close(sendLoopDone)
+		tcpSendLoop(endWrite, conns, tracker, messageChan, sendChan, receiveChan, splitHandler, timeOut, awakeChan)
+	}()
+
 	initChan <- struct{}{}
+	messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("Started TCP client")}
 	<-endChan
+	close(endWrite)
+	<-sendLoopDone
+	messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("Stopped TCP send loop.")}
+	conns.CloseAll()
+	conns.Wait()
+	messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("TCP client stopped.")}
 	doneChan <- struct{}{}
 }
 
-func splitSendPacketsLoop(genChan <-chan Packet, udpChan, tcpChan chan<- Packet) {
+func splitSendPacketsLoop(endChan <-chan struct{}, genChan <-chan Packet, udpChan, tcpChan chan<- Packet) {
 	for {
-		packet := <-genChan
-		if packet.Type.IsTCP() {
-			tcpChan <- packet
-		} else {
-			udpChan <- packet
+		select {
+		case packet := <-genChan:
+			targetChan := udpChan
+			if packet.Type.IsTCP() {
+				targetChan = tcpChan
+			}
+			select {
+			case targetChan <- packet:
+			case <-endChan:
+				return
+			}
+		case <-endChan:
+			return
+		}
+	}
+}
+
+// NetworkSettings tune the network server and connect it to the protocol specific logic.
+type NetworkSettings struct {
+	// Parses the received packet and returns the packets to be sent in response.
+	ParseHandler func(Packet) []Packet
+	// Returns the stream framing for the TCP request. Optional.
+	SplitHandler func(Packet) bufio.SplitFunc
+	// Returns the id of the request the response answers. Optional.
+	IdentifyHandler func(Packet) string
+	// Returns the retry count and the initial backoff for the UDP request. Optional.
+	RetryHandler func(Packet) (int, time.Duration)
+	// The servers stop after this duration without traffic. Requests which get no response are given up after this duration unless the retry handler says otherwise.
+	TimeOut time.Duration
+	// Packets sent per second. Zero means no limit.
+	SendRate int
+	// Number of hosts with requests queued for sending or awaiting response. Zero means no limit.
+	MaxInFlight int
+	// Called for every request handed over to the network. Optional.
+	SentHandler func(Packet)
+	// Called for every request once its response has been handled, or once it could not be sent or has been given up. Optional.
+	DoneHandler func(Packet)
+	// UDP sockets used instead of opening new ones. They are left open once the server stops. Optional.
+	UDPSockets *UDPSockets
+}
+
+// Returns the index of the first queued packet which may be sent without exceeding the limit of hosts in flight, -1 if there is none.
+func nextSendablePacket(queue []Packet, tracker *RequestTracker, maxInFlight int) int {
+	if len(queue) == 0 {
+		return -1
+	}
+	if maxInFlight <= 0 || tracker.InFlightNum() < maxInFlight {
+		return 0
+	}
+	for i, packet := range queue {
+		if tracker.InFlight(packet.RemoteAddr) {
+			return i
 		}
 	}
+	return -1
 }
 
-func AsyncNetworkServer(initChan, doneChan chan<- struct{}, messageChan chan<- ConsoleMsg, sendChan, receiveChan chan Packet, parseHandler func(Packet) []Packet, timeOut time.Duration) {
+// Releases the packets at no more than sendRate packets per second while keeping at most maxInFlight hosts in flight. Zero values mean no limit.
+// Re-sent requests take precedence over the new ones. Queued packets keep the servers awake.
+func pacedSendLoop(endChan <-chan struct{}, genChan <-chan Packet, outChan chan<- Packet, tracker *RequestTracker, sendRate int, maxInFlight int, awakeChan chan<- struct{}) {
+	queue := []Packet{}
+	retryQueue := []Packet{}
+
+	burst := math.Max(1, float64(sendRate)*PACER_POLL_INTERVAL.Seconds())
+	tokens := burst
+	lastRefill := time.Now()
+
+	ticker := time.NewTicker(PACER_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		if sendRate > 0 {
+			now := time.Now()
+			tokens = math.Min(burst, tokens+now.Sub(lastRefill).Seconds()*float64(sendRate))
+			lastRefill = now
+		}
+
+		for sendRate <= 0 || tokens >= 1 {
+			var packet Packet
+			if len(retryQueue) > 0 {
+				packet, retryQueue = retryQueue[0], retryQueue[1:]
+			} else if i := nextSendablePacket(queue, tracker, maxInFlight); i >= 0 {
+				packet = queue[i]
+				queue = append(queue[:i], queue[i+1:]...)
+				tracker.Queue(packet)
+			} else {
+				break
+			}
+			select {
+			case outChan <- packet:
+			case <-endChan:
+				return
+			}
+			tokens--
+		}
+
+		if len(queue) > 0 || len(retryQueue) > 0 {
+			wake(awakeChan)
+		}
+
+		select {
+		case packet := <-genChan:
+			if packet.Attempt > 0 {
+				retryQueue = append(retryQueue, packet)
+			} else {
+				queue = append(queue, packet)
+			}
+		case <-ticker.C:
+		case <-endChan:
+			return
+		}
+	}
+}
+
+// Runs the UDP server and TCP client until there is no traffic for the timeout duration or the cancel channel is closed.
+// Responses are matched to the requests by remote address and the request id returned by the identify handler, falling back to the oldest pending request if the id is empty.
+func AsyncNetworkServer(initChan, doneChan chan<- struct{}, cancelChan <-chan struct{}, messageChan chan<- ConsoleMsg, sendChan, receiveChan chan Packet, settings NetworkSettings) {
+	parseHandler := settings.ParseHandler
+	splitHandler := settings.SplitHandler
+	if splitHandler == nil {
+		splitHandler = func(Packet) bufio.SplitFunc { return nil }
+	}
+	identifyHandler := settings.IdentifyHandler
+	timeOut := settings.TimeOut
+	retryHandler := func(packet Packet) (int, time.Duration) {
+		var retries int
+		var backoff time.Duration
+		if settings.RetryHandler != nil {
+			retries, backoff = settings.RetryHandler(packet)
+		}
+		if backoff <= 0 {
+			backoff = timeOut
+		}
+		return retries, backoff
+	}
+
 	awakeChan := make(chan struct{}, 9999)
+	tracker := MakeRequestTracker(settings.SentHandler, settings.DoneHandler)
 
 	udpKillChan := make(chan struct{}, 1)
 	tcpKillChan := make(chan struct{}, 1)
@@ -151,22 +614,42 @@ func AsyncNetworkServer(initChan, doneChan chan<- struct{}, messageChan chan<- C
 	udpSendChan := make(chan Packet)
 	tcpSendChan := make(chan Packet)
 
-	endCallbackChan := make(chan struct{})
+	// Closed once both servers have stopped.
+	endLoops := make(chan struct{})
+	var loops sync.WaitGroup
 
-	go splitSendPacketsLoop(sendChan, udpSendChan, tcpSendChan)
+	startLoop := func(loop func()) {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			loop()
+		}()
+	}
+
+	pacedSendChan := make(chan Packet)
 
-	go AsyncUDPServer(udpKillChan, udpStartedChan, udpStoppedChan, messageChan, udpSendChan, receiveChan, parseHandler, timeOut, awakeChan)
-	go AsyncTCPServer(tcpKillChan, tcpStartedChan, tcpStoppedChan, messageChan, tcpSendChan, receiveChan, parseHandler, timeOut, awakeChan)
+	startLoop(func() {
+		pacedSendLoop(endLoops, sendChan, pacedSendChan, tracker, settings.SendRate, settings.MaxInFlight, awakeChan)
+	})
+	startLoop(func() { splitSendPacketsLoop(endLoops, pacedSendChan, udpSendChan, tcpSendChan) })
 
-	go receiveHandlerLoop(endCallbackChan, receiveChan, sendChan, receiveHandler, parseHandler, awakeChan)
+	go AsyncUDPServer(udpKillChan, udpStartedChan, udpStoppedChan, messageChan, udpSendChan, receiveChan, tracker, timeOut, awakeChan, settings.UDPSockets)
+	go AsyncTCPServer(tcpKillChan, tcpStartedChan, tcpStoppedChan, messageChan, tcpSendChan, receiveChan, splitHandler, tracker, timeOut, awakeChan)
+
+	startLoop(func() {
+		receiveHandlerLoop(endLoops, receiveChan, sendChan, receiveHandler, parseHandler, tracker, identifyHandler, awakeChan)
+	})
 
 	<-udpStartedChan
 	<-tcpStartedChan
 	initChan <- struct{}{}
 
-	go keepAliveLoop(awakeChan, timeOut, udpKillChan, tcpKillChan)
+	startLoop(func() { retryLoop(endLoops, tracker, retryHandler, messageChan, sendChan) })
+	startLoop(func() { keepAliveLoop(awakeChan, timeOut, cancelChan, udpKillChan, tcpKillChan) })
 
 	<-udpStoppedChan
 	<-tcpStoppedChan
+	close(endLoops)
+	loops.Wait()
 	doneChan <- struct{}{}
 }
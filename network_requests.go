@@ -0,0 +1,223 @@
+package grokstat
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type pendingRequest struct {
+	packet Packet
+	sent   time.Time
+}
+
+// Keeps track of the requests awaiting response so that responses can be matched to them.
+// A host is in flight while it has requests queued for sending or awaiting response.
+// The sent handler, if any, is called for every request handed over to the network. The done handler, if any, is called for every request which has been answered and handled, could not be sent or has been given up.
+type RequestTracker struct {
+	sync.Mutex
+	data        map[string][]pendingRequest
+	queued      map[string]int
+	sentHandler func(Packet)
+	doneHandler func(Packet)
+}
+
+func MakeRequestTracker(sentHandler func(Packet), doneHandler func(Packet)) *RequestTracker {
+	return &RequestTracker{data: map[string][]pendingRequest{}, queued: map[string]int{}, sentHandler: sentHandler, doneHandler: doneHandler}
+}
+
+func (t *RequestTracker) done(packets ...Packet) {
+	if t.doneHandler == nil {
+		return
+	}
+	for _, packet := range packets {
+		t.doneHandler(packet)
+	}
+}
+
+func (t *RequestTracker) unqueue(remoteAddr string) {
+	if t.queued[remoteAddr] > 1 {
+		t.queued[remoteAddr]--
+	} else {
+		delete(t.queued, remoteAddr)
+	}
+}
+
+// Registers the request as handed over for sending.
+func (t *RequestTracker) Queue(packet Packet) {
+	t.Lock()
+	defer t.Unlock()
+	t.queued[packet.RemoteAddr]++
+}
+
+// Registers the queued request as sent at the specified time.
+func (t *RequestTracker) Sent(packet Packet, sent time.Time) {
+	t.Lock()
+	t.unqueue(packet.RemoteAddr)
+	t.data[packet.RemoteAddr] = append(t.data[packet.RemoteAddr], pendingRequest{packet: packet, sent: sent})
+	t.Unlock()
+	if t.sentHandler != nil {
+		t.sentHandler(packet)
+	}
+}
+
+// Unregisters the queued request which could not be sent.
+func (t *RequestTracker) Dropped(packet Packet) {
+	t.Lock()
+	t.unqueue(packet.RemoteAddr)
+	t.Unlock()
+	t.done(packet)
+}
+
+// Registers the response to the request as handled.
+func (t *RequestTracker) Handled(request Packet) {
+	t.done(request)
+}
+
+func (t *RequestTracker) InFlight(remoteAddr string) bool {
+	t.Lock()
+	defer t.Unlock()
+	return t.queued[remoteAddr] > 0 || len(t.data[remoteAddr]) > 0
+}
+
+// Returns the number of hosts in flight.
+func (t *RequestTracker) InFlightNum() int {
+	t.Lock()
+	defer t.Unlock()
+	num := len(t.data)
+	for remoteAddr := range t.queued {
+		if _, exists := t.data[remoteAddr]; !exists {
+			num++
+		}
+	}
+	return num
+}
+
+// Matches the response to the oldest pending request with the specified id. If the id is empty, the oldest pending request for the remote address is used.
+func (t *RequestTracker) Received(remoteAddr string, requestId string, received time.Time) (request Packet, roundTripTime time.Duration, ok bool) {
+	t.Lock()
+	defer t.Unlock()
+	pending := t.data[remoteAddr]
+	for i, v := range pending {
+		if requestId != "" && v.packet.Id != requestId {
+			continue
+		}
+		t.data[remoteAddr] = append(pending[:i:i], pending[i+1:]...)
+		if len(t.data[remoteAddr]) == 0 {
+			delete(t.data, remoteAddr)
+		}
+		return v.packet, received.Sub(v.sent), true
+	}
+	return Packet{}, 0, false
+}
+
+// Removes the pending requests which have not been answered in time. UDP requests with attempts left are returned with their attempt number increased and stay in flight until re-sent, the others are given up.
+func (t *RequestTracker) Due(now time.Time, retryPolicy func(Packet) (int, time.Duration)) []Packet {
+	t.Lock()
+	duePackets := []Packet{}
+	expiredPackets := []Packet{}
+	for remoteAddr, pending := range t.data {
+		remaining := pending[:0]
+		for _, v := range pending {
+			retries, backoff := retryPolicy(v.packet)
+			if v.packet.Type.IsTCP() {
+				retries = 0
+			}
+			if now.Sub(v.sent) < backoff<<uint(v.packet.Attempt) {
+				remaining = append(remaining, v)
+				continue
+			}
+			if v.packet.Attempt < retries {
+				v.packet.Attempt++
+				t.queued[remoteAddr]++
+				duePackets = append(duePackets, v.packet)
+			} else {
+				expiredPackets = append(expiredPackets, v.packet)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(t.data, remoteAddr)
+		} else {
+			t.data[remoteAddr] = remaining
+		}
+	}
+	t.Unlock()
+	t.done(expiredPackets...)
+	return duePackets
+}
+
+// Re-sends the requests which have not been answered in time until the end channel is closed.
+func retryLoop(endChan <-chan struct{}, tracker *RequestTracker, retryPolicy func(Packet) (int, time.Duration), messageChan chan<- ConsoleMsg, sendChan chan<- Packet) {
+	ticker := time.NewTicker(RETRY_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, packet := range tracker.Due(now, retryPolicy) {
+				retries, _ := retryPolicy(packet)
+				messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - No response to %s, retrying (%d/%d).", packet.ProtocolId, packet.RemoteAddr, packet.Id, packet.Attempt, retries)}
+				select {
+				case sendChan <- packet:
+				case <-endChan:
+					return
+				}
+			}
+		case <-endChan:
+			return
+		}
+	}
+}
+
+func durationMilliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Computes the statistics of the round-trip time samples. Jitter is the mean difference between consecutive samples.
+func MakePingStats(samples []time.Duration) PingStats {
+	stats := PingStats{Samples: len(samples)}
+	if len(samples) == 0 {
+		return stats
+	}
+
+	min, max := samples[0], samples[0]
+	var sum, jitterSum time.Duration
+	for i, sample := range samples {
+		if sample < min {
+			min = sample
+		}
+		if sample > max {
+			max = sample
+		}
+		sum += sample
+		if i > 0 {
+			diff := sample - samples[i-1]
+			if diff < 0 {
+				diff = -diff
+			}
+			jitterSum += diff
+		}
+	}
+
+	stats.Min = durationMilliseconds(min)
+	stats.Max = durationMilliseconds(max)
+	stats.Avg = durationMilliseconds(sum) / float64(len(samples))
+	if len(samples) > 1 {
+		stats.Jitter = durationMilliseconds(jitterSum) / float64(len(samples)-1)
+	}
+	return stats
+}
+
+// Matches the response to its request and fills in the request id and the round-trip time. Returns the response along with the matched request, if any.
+func trackResponse(tracker *RequestTracker, identifyHandler func(Packet) string, packet Packet) (Packet, Packet, bool) {
+	var requestId string
+	if identifyHandler != nil {
+		requestId = identifyHandler(packet)
+	}
+	request, roundTripTime, ok := tracker.Received(packet.RemoteAddr, requestId, packet.ReceiveTime)
+	if ok {
+		packet.Id = request.Id
+		packet.RoundTripTime = roundTripTime
+		packet.Ping = int64(roundTripTime / time.Millisecond)
+	}
+	return packet, request, ok
+}
@@ -0,0 +1,47 @@
+package grokstat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestTracker(t *testing.T) {
+	var err error
+	expectation := []string{"A2S_PLAYER", "A2S_INFO"}
+
+	tracker := MakeRequestTracker(nil, nil)
+	sent := time.Now()
+	tracker.Sent(Packet{Id: "A2S_INFO", RemoteAddr: "127.0.0.1:27015"}, sent)
+	tracker.Sent(Packet{Id: "A2S_PLAYER", RemoteAddr: "127.0.0.1:27015"}, sent.Add(time.Millisecond))
+
+	result := []string{}
+	request, roundTripTime, ok := tracker.Received("127.0.0.1:27015", "A2S_PLAYER", sent.Add(5*time.Millisecond))
+	if !ok || roundTripTime != 4*time.Millisecond {
+		err = CompError
+	}
+	result = append(result, request.Id)
+
+	request, roundTripTime, ok = tracker.Received("127.0.0.1:27015", "", sent.Add(5*time.Millisecond))
+	if !ok || roundTripTime != 5*time.Millisecond {
+		err = CompError
+	}
+	result = append(result, request.Id)
+
+	if _, _, ok = tracker.Received("127.0.0.1:27015", "", sent.Add(5*time.Millisecond)); ok {
+		err = CompError
+	}
+
+	if err != nil || result[0] != expectation[0] || result[1] != expectation[1] {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestMakePingStats(t *testing.T) {
+	expectation := PingStats{Samples: 3, Min: 10, Avg: 20, Max: 30, Jitter: 15}
+
+	result := MakePingStats([]time.Duration{10 * time.Millisecond, 30 * time.Millisecond, 20 * time.Millisecond})
+
+	if result != expectation {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
@@ -0,0 +1,139 @@
+package grokstat
+
+import (
+	"bufio"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func splitBytePrefixed(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) == 0 || len(data) < int(data[0])+1 {
+		return 0, nil, nil
+	}
+	frameLen := int(data[0]) + 1
+	return frameLen, data[1:frameLen], nil
+}
+
+func TestAsyncTCPServer(t *testing.T) {
+	var err error
+	expectation := []string{"grok", "stat"}
+
+	listener, lErr := net.Listen("tcp", "127.0.0.1:0")
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, cErr := listener.Accept()
+		if cErr != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 4)
+		conn.Read(buf)
+		conn.Write([]byte("\x04grok\x04stat"))
+	}()
+
+	var resultLock sync.Mutex
+	result := []string{}
+
+	messageChan := make(chan ConsoleMsg, 9999)
+	sendChan := make(chan Packet, 1)
+	receiveChan := make(chan Packet, 9999)
+	initChan := make(chan struct{})
+	doneChan := make(chan struct{})
+
+	parseHandler := func(packet Packet) []Packet {
+		resultLock.Lock()
+		defer resultLock.Unlock()
+		result = append(result, string(packet.Data))
+		return nil
+	}
+	splitHandler := func(packet Packet) bufio.SplitFunc {
+		return splitBytePrefixed
+	}
+
+	sendChan <- Packet{Id: "ping", Type: TYPE_TCP, RemoteAddr: listener.Addr().String(), Data: []byte("ping")}
+	go AsyncNetworkServer(initChan, doneChan, nil, messageChan, sendChan, receiveChan, NetworkSettings{ParseHandler: parseHandler, SplitHandler: splitHandler, TimeOut: 500 * time.Millisecond})
+	<-initChan
+	<-doneChan
+
+	resultLock.Lock()
+	defer resultLock.Unlock()
+	// Frames may be handled in any order.
+	sort.Strings(expectation)
+	sort.Strings(result)
+
+	if len(result) != len(expectation) {
+		err = CompError
+	} else {
+		for i := range result {
+			if result[i] != expectation[i] {
+				err = CompError
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestPacedSendLoop(t *testing.T) {
+	var err error
+	expectation := []string{"127.0.0.1:1", "127.0.0.1:1", "127.0.0.1:2"}
+
+	endChan := make(chan struct{})
+	defer close(endChan)
+	genChan := make(chan Packet, 9999)
+	outChan := make(chan Packet)
+	awakeChan := make(chan struct{}, 9999)
+	tracker := MakeRequestTracker(nil, nil)
+
+	genChan <- Packet{RemoteAddr: "127.0.0.1:1"}
+	genChan <- Packet{RemoteAddr: "127.0.0.1:2"}
+	genChan <- Packet{RemoteAddr: "127.0.0.1:1"}
+
+	start := time.Now()
+	go pacedSendLoop(endChan, genChan, outChan, tracker, 50, 1, awakeChan)
+
+	result := []string{}
+	for i := 0; i < 2; i++ {
+		packet := <-outChan
+		tracker.Sent(packet, time.Now())
+		result = append(result, packet.RemoteAddr)
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf(ErrorOut(">= 15ms", elapsed))
+	}
+
+	select {
+	case packet := <-outChan:
+		err = CompError
+		result = append(result, packet.RemoteAddr)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	tracker.Received("127.0.0.1:1", "", time.Now())
+	tracker.Received("127.0.0.1:1", "", time.Now())
+	result = append(result, (<-outChan).RemoteAddr)
+
+	if len(result) != len(expectation) {
+		err = CompError
+	} else {
+		for i := range result {
+			if result[i] != expectation[i] {
+				err = CompError
+			}
+		}
+	}
+
+	if err != nil {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
@@ -1,17 +1,234 @@
-package main
+package grokstat
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
+	"sync"
+)
+
+const (
+	A2S_CHALLENGE_RESPONSE = 0x41
+	A2S_INFO_RESPONSE      = 0x49
+	A2S_PLAYER_RESPONSE    = 0x44
+	A2S_RULES_RESPONSE     = 0x45
 )
 
 func A2SMakeProtocolTemplate() ProtocolEntry {
-	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: MakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "A2S_INFO"}}, HandlerFunc: func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: A2SMakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "A2S_INFO"}, RequestPacket{Id: "A2S_PLAYER"}, RequestPacket{Id: "A2S_RULES"}}, PrepareQueryFunc: A2SPrepareQuery, ResponseIdFunc: A2SResponseId, ResponseMatchFunc: A2SMatchResponse, HttpProtocol: "udp", ResponseType: "Server info"}, Information: ProtocolEntryInfo{"Name": "Source Engine Server", "DefaultRequestPort": "27015", "SplitPacketFormat": "source", "RequestPreludeTemplate": "\xff\xff\xff\xffTSource Engine Query\x00", "ResponsePreludeTemplate": "\xFF\xFF\xFF\xFF"}}
+}
+
+// Keeps the requests sent without a challenge number for each host, in the order they were sent, until they are answered or challenged.
+type A2SChallengeCollection struct {
+	sync.Mutex
+	requestIds []string
+	data       map[string][]string
+}
+
+func MakeA2SChallengeCollection(requestIds []string) *A2SChallengeCollection {
+	return &A2SChallengeCollection{requestIds: requestIds, data: map[string][]string{}}
+}
+
+func (c *A2SChallengeCollection) waiting(remoteAddr string) []string {
+	requestIds, exists := c.data[remoteAddr]
+	if !exists {
+		requestIds = append([]string{}, c.requestIds...)
+	}
+	return requestIds
+}
+
+// Returns the id of the oldest request which has not been answered or challenged yet. Returns an empty string if there is none.
+func (c *A2SChallengeCollection) Challenged(remoteAddr string) string {
+	c.Lock()
+	defer c.Unlock()
+	requestIds := c.waiting(remoteAddr)
+	if len(requestIds) == 0 {
+		return ""
+	}
+	c.data[remoteAddr] = requestIds[1:]
+	return requestIds[0]
+}
+
+// Registers the request as answered without a challenge.
+func (c *A2SChallengeCollection) Answered(remoteAddr string, requestId string) {
+	c.Lock()
+	defer c.Unlock()
+	requestIds := c.waiting(remoteAddr)
+	for i, v := range requestIds {
+		if v == requestId {
+			requestIds = append(requestIds[:i:i], requestIds[i+1:]...)
+			break
+		}
+	}
+	c.data[remoteAddr] = requestIds
+}
+
+// Sets up the handlers for one query along with the split packet fragments and challenges it keeps. Split responses are reassembled while identifying them, so the complete response is matched to the request it answers.
+// Servers answer the requests in the order they were sent, so a challenge response is matched to the oldest request which has not been answered or challenged yet. Challenge responses left over once every request has been answered or challenged are not matched to any request.
+func A2SPrepareQuery(base *ProtocolEntryBase) {
+	requestIds := []string{}
+	for _, requestPacket := range base.RequestPackets {
+		requestIds = append(requestIds, requestPacket.Id)
+	}
+	splitPackets := MakeA2SSplitPacketCollection()
+	challenges := MakeA2SChallengeCollection(requestIds)
+	base.ResponseIdFunc = func(packet Packet, protocolInfo ProtocolEntryInfo) string {
+		if splitBody, isSplit := CheckPrelude(packet.Data, []byte("\xFF\xFF\xFF\xFE")); isSplit {
+			payload, complete := splitPackets.Identify(packet.RemoteAddr, splitBody, protocolInfo["SplitPacketFormat"] == "goldsrc")
+			if !complete {
+				return "A2S_SPLIT"
+			}
+			packet.Data = payload
+		}
+		if body, preludeOk := CheckPrelude(packet.Data, []byte("\xFF\xFF\xFF\xFF")); preludeOk && len(body) > 0 && body[0] == A2S_CHALLENGE_RESPONSE {
+			if requestId := challenges.Challenged(packet.RemoteAddr); requestId != "" {
+				return requestId
+			}
+			return "A2S_CHALLENGE"
+		}
+		requestId := A2SResponseId(packet, protocolInfo)
+		if requestId != "" {
+			challenges.Answered(packet.RemoteAddr, requestId)
+		}
+		return requestId
+	}
+	base.HandlerFunc = func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+		return A2SHandler(splitPackets, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	}
+}
+
+// Builds the request. Player and rules requests without a known challenge ask the server for one.
+func makeA2SRequest(packetId string, challenge []byte, protocolInfo ProtocolEntryInfo) []byte {
+	var data []byte
+	switch packetId {
+	case "A2S_INFO":
+		data = []byte(ParseTemplate(protocolInfo["RequestPreludeTemplate"], protocolInfo))
+	case "A2S_PLAYER":
+		data = []byte("\xFF\xFF\xFF\xFF\x55")
+		if challenge == nil {
+			challenge = []byte("\xFF\xFF\xFF\xFF")
+		}
+	case "A2S_RULES":
+		data = []byte("\xFF\xFF\xFF\xFF\x56")
+		if challenge == nil {
+			challenge = []byte("\xFF\xFF\xFF\xFF")
+		}
+	default:
+		return nil
+	}
+	return append(data, challenge...)
+}
+
+// Returns the id of the request the response answers. Fragments of split responses are not matched to any request, the query matches the reassembled response instead. Challenge responses do not tell the request apart, the query matches them to the challenged request.
+func A2SResponseId(packet Packet, protocolInfo ProtocolEntryInfo) string {
+	if _, isSplit := CheckPrelude(packet.Data, []byte("\xFF\xFF\xFF\xFE")); isSplit {
+		return "A2S_SPLIT"
+	}
+	body, preludeOk := CheckPrelude(packet.Data, []byte("\xFF\xFF\xFF\xFF"))
+	if !preludeOk || len(body) == 0 {
+		return ""
+	}
+	switch body[0] {
+	case A2S_INFO_RESPONSE:
+		return "A2S_INFO"
+	case A2S_PLAYER_RESPONSE:
+		return "A2S_PLAYER"
+	case A2S_RULES_RESPONSE:
+		return "A2S_RULES"
+	default:
+		return ""
+	}
+}
+
+// Matches the split responses and the responses with known headers.
+func A2SMatchResponse(packet Packet, protocolInfo ProtocolEntryInfo) bool {
+	if splitBody, isSplit := CheckPrelude(packet.Data, []byte("\xFF\xFF\xFF\xFE")); isSplit {
+		return len(splitBody) > 0
+	}
+	body, preludeOk := CheckPrelude(packet.Data, []byte(protocolInfo["ResponsePreludeTemplate"]))
+	if !preludeOk || len(body) == 0 {
+		return false
+	}
+	switch body[0] {
+	case A2S_CHALLENGE_RESPONSE, A2S_INFO_RESPONSE, A2S_PLAYER_RESPONSE, A2S_RULES_RESPONSE:
+		return true
+	default:
+		return false
+	}
+}
+
+func A2SMakePayload(packet Packet, protocolInfo ProtocolEntryInfo) Packet {
+	packet.Data = makeA2SRequest(packet.Id, nil, protocolInfo)
+	return packet
+}
+
+// Dispatches the response according to its header. Split responses are reassembled first, challenge responses are answered by re-sending the challenged request with the challenge number.
+func A2SHandler(splitPackets *A2SSplitPacketCollection, packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+	sendPackets = []Packet{}
+
+	protocolId := packet.ProtocolId
+	protocol, protocolExists := protocolCollection.Get(protocolId)
+	if !protocolExists {
+		return sendPackets
+	}
+	protocolInfo := protocol.Information
+	remoteIp := packet.RemoteAddr
+
+	if splitBody, isSplit := CheckPrelude(packet.Data, []byte("\xFF\xFF\xFF\xFE")); isSplit {
+		payload, complete, err := splitPackets.Take(remoteIp, splitBody, protocolInfo["SplitPacketFormat"] == "goldsrc")
+		if err != nil {
+			messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, err.Error())}
+			return sendPackets
+		}
+		if !complete {
+			return sendPackets
+		}
+		messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - Reassembled %d bytes from split response.", protocolId, remoteIp, len(payload))}
+		packet.Data = payload
+	}
+
+	body, preludeOk := CheckPrelude(packet.Data, []byte(protocolInfo["ResponsePreludeTemplate"]))
+	if !preludeOk || len(body) == 0 {
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, InvalidResponseHeader.Error())}
+		serverEntryChan <- MakeErrorServerEntry(remoteIp, protocolId, InvalidResponseHeader)
+		return sendPackets
+	}
+
+	switch body[0] {
+	case A2S_CHALLENGE_RESPONSE:
+		if len(body) < 5 {
+			messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, InvalidResponseChallenge.Error())}
+			return sendPackets
+		}
+		challenge := body[1:5]
+		messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - Received challenge %s.", protocolId, remoteIp, GetByteString(challenge))}
+		data := makeA2SRequest(packet.Id, challenge, protocolInfo)
+		if data == nil {
+			messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - Challenge does not answer any request.", protocolId, remoteIp)}
+			return sendPackets
+		}
+		return append(sendPackets, Packet{Id: packet.Id, Type: packet.Type, RemoteAddr: remoteIp, ProtocolId: protocolId, Data: data})
+	case A2S_INFO_RESPONSE:
 		return SimpleReceiveHandler(A2SparsePacket, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
-	}, HttpProtocol: "udp", ResponseType: "Server list"}, Information: ProtocolEntryInfo{"Name": "Source Engine Server", "DefaultRequestPort": "27015", "RequestPreludeTemplate": "\xff\xff\xff\xffTSource Engine Query\x00", "ResponsePreludeTemplate": "\xFF\xFF\xFF\xFF"}}
+	case A2S_PLAYER_RESPONSE:
+		return SimpleReceiveHandler(A2SparsePlayerPacket, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	case A2S_RULES_RESPONSE:
+		return SimpleReceiveHandler(A2SparseRulesPacket, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	default:
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, InvalidServerHeader.Error())}
+		serverEntryChan <- MakeErrorServerEntry(remoteIp, protocolId, InvalidServerHeader)
+		return sendPackets
+	}
 }
 
-func A2SparsePacket(packet Packet, protocolInfo ProtocolEntryInfo) (ServerEntry, error) {
+func A2SparsePacket(packet Packet, protocolInfo ProtocolEntryInfo) (entry ServerEntry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			entry = MakeServerEntry()
+			err = MalformedPacket
+		}
+	}()
 	preludeTemplate, pTOk := protocolInfo["ResponsePreludeTemplate"]
 	var body []byte
 	var preludeOk bool
@@ -28,7 +245,7 @@ func A2SparsePacket(packet Packet, protocolInfo ProtocolEntryInfo) (ServerEntry,
 	}
 	entryBuf := bytes.NewBuffer(body)
 	serverHeader := entryBuf.Next(1)
-	if !bytes.Equal(serverHeader, []byte{0x49}) {
+	if !bytes.Equal(serverHeader, []byte{A2S_INFO_RESPONSE}) {
 		return ServerEntry{}, InvalidServerHeader
 	}
 	protocolVer := entryBuf.Next(1)
@@ -148,3 +365,95 @@ func A2SparsePacket(packet Packet, protocolInfo ProtocolEntryInfo) (ServerEntry,
 
 	return serverEntry, nil
 }
+
+func A2SparsePlayerPacket(packet Packet, protocolInfo ProtocolEntryInfo) (ServerEntry, error) {
+	body, preludeOk := CheckPrelude(packet.Data, []byte(protocolInfo["ResponsePreludeTemplate"]))
+	if !preludeOk {
+		return ServerEntry{}, InvalidResponseHeader
+	}
+	return A2SparsePlayerData(body)
+}
+
+// Parses the A2S_PLAYER response body.
+func A2SparsePlayerData(body []byte) (serverEntry ServerEntry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			serverEntry = MakeServerEntry()
+			err = MalformedPacket
+		}
+	}()
+	entryBuf := bytes.NewBuffer(body)
+	if entryBuf.Next(1)[0] != A2S_PLAYER_RESPONSE {
+		return MakeServerEntry(), InvalidServerHeader
+	}
+
+	playerNum := int(entryBuf.Next(1)[0])
+
+	players := []PlayerEntry{}
+	for i := 0; i < playerNum && entryBuf.Len() > 0; i++ {
+		playerIndex := int(entryBuf.Next(1)[0])
+		playerNameRaw, playerNameErr := entryBuf.ReadBytes(byte(0))
+		if playerNameErr != nil {
+			return MakeServerEntry(), InvalidPlayerString
+		}
+		if entryBuf.Len() < 8 {
+			return MakeServerEntry(), InvalidPlayerStringLength
+		}
+		score := int32(binary.LittleEndian.Uint32(entryBuf.Next(4)))
+		duration := math.Float32frombits(binary.LittleEndian.Uint32(entryBuf.Next(4)))
+
+		playerEntry := MakePlayerEntry()
+		playerEntry.Name = string(bytes.Trim(playerNameRaw, "\x00"))
+		playerEntry.Info["index"] = fmt.Sprint(playerIndex)
+		playerEntry.Info["score"] = fmt.Sprint(score)
+		playerEntry.Info["duration"] = fmt.Sprint(duration)
+		players = append(players, playerEntry)
+	}
+
+	serverEntry = MakeServerEntry()
+	serverEntry.Players = players
+
+	return serverEntry, nil
+}
+
+func A2SparseRulesPacket(packet Packet, protocolInfo ProtocolEntryInfo) (ServerEntry, error) {
+	body, preludeOk := CheckPrelude(packet.Data, []byte(protocolInfo["ResponsePreludeTemplate"]))
+	if !preludeOk {
+		return ServerEntry{}, InvalidResponseHeader
+	}
+	return A2SparseRulesData(body)
+}
+
+// Parses the A2S_RULES response body.
+func A2SparseRulesData(body []byte) (serverEntry ServerEntry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			serverEntry = MakeServerEntry()
+			err = MalformedPacket
+		}
+	}()
+	entryBuf := bytes.NewBuffer(body)
+	if entryBuf.Next(1)[0] != A2S_RULES_RESPONSE {
+		return MakeServerEntry(), InvalidServerHeader
+	}
+
+	ruleNum := int(binary.LittleEndian.Uint16(entryBuf.Next(2)))
+
+	rules := map[string]string{}
+	for i := 0; i < ruleNum && entryBuf.Len() > 0; i++ {
+		ruleNameRaw, ruleNameErr := entryBuf.ReadBytes(byte(0))
+		if ruleNameErr != nil {
+			return MakeServerEntry(), InvalidRuleString
+		}
+		ruleValueRaw, ruleValueErr := entryBuf.ReadBytes(byte(0))
+		if ruleValueErr != nil {
+			return MakeServerEntry(), InvalidRuleString
+		}
+		rules[string(bytes.Trim(ruleNameRaw, "\x00"))] = string(bytes.Trim(ruleValueRaw, "\x00"))
+	}
+
+	serverEntry = MakeServerEntry()
+	serverEntry.Rules = rules
+
+	return serverEntry, nil
+}
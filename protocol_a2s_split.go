@@ -0,0 +1,171 @@
+package grokstat
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+const (
+	A2S_SPLIT_PACKET_EXPIRY   = 30 * time.Second
+	A2S_MAX_DECOMPRESSED_SIZE = 1 << 20
+)
+
+type a2sSplitPacket struct {
+	total            int
+	fragments        map[int][]byte
+	compressed       bool
+	decompressedSize uint32
+	checksum         uint32
+	updated          time.Time
+}
+
+type a2sSplitResult struct {
+	payload  []byte
+	complete bool
+	err      error
+}
+
+// Collects fragments of split responses keyed by remote address and packet id.
+// The results of the fragments added while identifying the responses are kept until their handlers take them.
+type A2SSplitPacketCollection struct {
+	sync.Mutex
+	data    map[string]*a2sSplitPacket
+	results map[string][]a2sSplitResult
+}
+
+func MakeA2SSplitPacketCollection() *A2SSplitPacketCollection {
+	return &A2SSplitPacketCollection{data: map[string]*a2sSplitPacket{}, results: map[string][]a2sSplitResult{}}
+}
+
+// Reads the split packet header: the packet id, the number of fragments and the fragment number.
+func readA2SSplitHeader(buf *bytes.Buffer, goldSrc bool) (packetId uint32, total int, number int) {
+	packetId = binary.LittleEndian.Uint32(buf.Next(4))
+	if goldSrc {
+		packetNum := int(buf.Next(1)[0])
+		number = packetNum >> 4
+		total = packetNum & 0x0F
+	} else {
+		total = int(buf.Next(1)[0])
+		number = int(buf.Next(1)[0])
+		_ = buf.Next(2)
+	}
+	return packetId, total, number
+}
+
+func a2sFragmentKey(remoteAddr string, data []byte, goldSrc bool) (key string, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			key, ok = "", false
+		}
+	}()
+	packetId, _, number := readA2SSplitHeader(bytes.NewBuffer(data), goldSrc)
+	return fmt.Sprintf("%s/%d/%d", remoteAddr, packetId, number), true
+}
+
+// Adds the fragment ahead of its handler, so that the complete response can be matched to the request it answers. Returns the payload once the response is complete.
+func (c *A2SSplitPacketCollection) Identify(remoteAddr string, data []byte, goldSrc bool) ([]byte, bool) {
+	payload, complete, err := c.Add(remoteAddr, data, goldSrc)
+	if key, ok := a2sFragmentKey(remoteAddr, data, goldSrc); ok {
+		c.Lock()
+		c.results[key] = append(c.results[key], a2sSplitResult{payload: payload, complete: complete, err: err})
+		c.Unlock()
+	}
+	return payload, complete && err == nil
+}
+
+// Returns the result of adding the identified fragment. Fragments which have not been identified are added.
+func (c *A2SSplitPacketCollection) Take(remoteAddr string, data []byte, goldSrc bool) (payload []byte, complete bool, err error) {
+	if key, ok := a2sFragmentKey(remoteAddr, data, goldSrc); ok {
+		c.Lock()
+		results, exists := c.results[key]
+		if exists {
+			if len(results) > 1 {
+				c.results[key] = results[1:]
+			} else {
+				delete(c.results, key)
+			}
+		}
+		c.Unlock()
+		if exists {
+			return results[0].payload, results[0].complete, results[0].err
+		}
+	}
+	return c.Add(remoteAddr, data, goldSrc)
+}
+
+// Stores the fragment (with the 0xFFFFFFFE header already stripped) and returns the complete payload once all fragments have arrived.
+func (c *A2SSplitPacketCollection) Add(remoteAddr string, data []byte, goldSrc bool) (payload []byte, complete bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			payload = nil
+			complete = false
+			err = MalformedPacket
+		}
+	}()
+	buf := bytes.NewBuffer(data)
+
+	packetId, total, number := readA2SSplitHeader(buf, goldSrc)
+	if total == 0 || number >= total {
+		return nil, false, MalformedPacket
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	for k, v := range c.data {
+		if now.Sub(v.updated) > A2S_SPLIT_PACKET_EXPIRY {
+			delete(c.data, k)
+		}
+	}
+
+	key := fmt.Sprintf("%s/%d", remoteAddr, packetId)
+	splitPacket, exists := c.data[key]
+	if !exists {
+		splitPacket = &a2sSplitPacket{total: total, fragments: map[int][]byte{}, compressed: !goldSrc && packetId&0x80000000 != 0}
+		c.data[key] = splitPacket
+	}
+	splitPacket.updated = now
+
+	if number == 0 && splitPacket.compressed {
+		splitPacket.decompressedSize = binary.LittleEndian.Uint32(buf.Next(4))
+		splitPacket.checksum = binary.LittleEndian.Uint32(buf.Next(4))
+	}
+	fragment := make([]byte, buf.Len())
+	copy(fragment, buf.Bytes())
+	splitPacket.fragments[number] = fragment
+
+	if len(splitPacket.fragments) < splitPacket.total {
+		return nil, false, nil
+	}
+	delete(c.data, key)
+
+	for i := 0; i < splitPacket.total; i++ {
+		payload = append(payload, splitPacket.fragments[i]...)
+	}
+
+	if splitPacket.compressed {
+		if splitPacket.decompressedSize > A2S_MAX_DECOMPRESSED_SIZE {
+			return nil, false, InvalidResponseLength
+		}
+		payload, err = ioutil.ReadAll(io.LimitReader(bzip2.NewReader(bytes.NewReader(payload)), int64(splitPacket.decompressedSize)+1))
+		if err != nil {
+			return nil, false, MalformedPacket
+		}
+		if uint32(len(payload)) != splitPacket.decompressedSize {
+			return nil, false, InvalidResponseLength
+		}
+		if crc32.ChecksumIEEE(payload) != splitPacket.checksum {
+			return nil, false, InvalidChecksum
+		}
+	}
+
+	return payload, true, nil
+}
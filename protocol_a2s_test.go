@@ -0,0 +1,187 @@
+package grokstat
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestA2SparsePlayerData(t *testing.T) {
+	var err error
+	s1 := []byte("\x44\x02\x00Grok\x00\x0A\x00\x00\x00\x00\x00\x20\x41\x01Stat\x00\xFE\xFF\xFF\xFF\x00\x00\xC8\x42")
+	expectation := []PlayerEntry{PlayerEntry{Name: "Grok", Info: map[string]string{"index": "0", "score": "10", "duration": "10"}}, PlayerEntry{Name: "Stat", Info: map[string]string{"index": "1", "score": "-2", "duration": "100"}}}
+
+	result, resultErr := A2SparsePlayerData(s1)
+
+	if resultErr != nil {
+		t.Errorf(resultErr.Error())
+	}
+
+	if len(result.Players) != len(expectation) {
+		err = CompError
+	} else {
+		for i := range result.Players {
+			if result.Players[i].Name != expectation[i].Name || fmt.Sprint(result.Players[i].Info) != fmt.Sprint(expectation[i].Info) {
+				err = CompError
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		t.Errorf(ErrorOut(expectation, result.Players))
+	}
+}
+
+func TestA2SparseRulesData(t *testing.T) {
+	var err error
+	s1 := []byte("\x45\x02\x00mp_timelimit\x0030\x00sv_cheats\x000\x00")
+	expectation := map[string]string{"mp_timelimit": "30", "sv_cheats": "0"}
+
+	result, resultErr := A2SparseRulesData(s1)
+
+	if resultErr != nil {
+		t.Errorf(resultErr.Error())
+	}
+
+	if len(result.Rules) != len(expectation) {
+		err = CompError
+	}
+
+	for i := range result.Rules {
+		if result.Rules[i] != expectation[i] {
+			err = CompError
+		}
+	}
+
+	if err != nil {
+		fmt.Println(MapComparison(expectation, result.Rules))
+		t.Errorf(ErrorOut(expectation, result.Rules))
+	}
+}
+
+func TestA2SChallenge(t *testing.T) {
+	var err error
+	s1 := [][]byte{[]byte("\xFF\xFF\xFF\xFF\x49"), []byte("\xFF\xFF\xFF\xFF\x41\x01\x02\x03\x04"), []byte("\xFF\xFF\xFF\xFF\x41\x01\x02\x03\x04"), []byte("\xFF\xFF\xFF\xFF\x41\x01\x02\x03\x04")}
+	expectation := []Packet{Packet{Id: "A2S_PLAYER", Data: []byte("\xFF\xFF\xFF\xFF\x55\x01\x02\x03\x04")}, Packet{Id: "A2S_RULES", Data: []byte("\xFF\xFF\xFF\xFF\x56\x01\x02\x03\x04")}}
+	idExpectation := []string{"A2S_INFO", "A2S_PLAYER", "A2S_RULES", "A2S_CHALLENGE"}
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "a2s", Template: "A2S"}}).ForQuery()
+	protocol, _ := protColl.Get("a2s")
+	messageChan := make(chan ConsoleMsg, 10)
+
+	result := []Packet{}
+	idResult := []string{}
+	for _, data := range s1 {
+		packet := Packet{Data: data, RemoteAddr: "127.0.0.1:27015", ProtocolId: "a2s"}
+		packet.Id = protocol.Base.ResponseIdFunc(packet, protocol.Information)
+		idResult = append(idResult, packet.Id)
+		if packet.Data[4] == A2S_CHALLENGE_RESPONSE {
+			result = append(result, protocol.Base.HandlerFunc(packet, protColl, messageChan, nil, nil)...)
+		}
+	}
+
+	if len(result) != len(expectation) {
+		err = CompError
+	} else {
+		for i := range result {
+			if result[i].Id != expectation[i].Id || string(result[i].Data) != string(expectation[i].Data) {
+				err = CompError
+			}
+		}
+	}
+
+	if err != nil {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+
+	if fmt.Sprint(idResult) != fmt.Sprint(idExpectation) {
+		t.Errorf(ErrorOut(idExpectation, idResult))
+	}
+}
+
+func TestA2SSplitPacketCollection(t *testing.T) {
+	s1 := [][]byte{[]byte("\x02\x00\x00\x00\x02\x01\xE0\x04les\x00"), []byte("\x02\x00\x00\x00\x02\x00\xE0\x04\xFF\xFF\xFF\xFFru")}
+	expectation := "\xFF\xFF\xFF\xFFrules\x00"
+
+	splitPackets := MakeA2SSplitPacketCollection()
+	var result []byte
+	var complete bool
+	for _, fragment := range s1 {
+		var resultErr error
+		result, complete, resultErr = splitPackets.Add("127.0.0.1:27015", fragment, false)
+		if resultErr != nil {
+			t.Errorf(resultErr.Error())
+		}
+	}
+
+	if !complete || string(result) != expectation {
+		t.Errorf(ErrorOut(expectation, string(result)))
+	}
+}
+
+func TestA2SSplitPacketCollectionCompressed(t *testing.T) {
+	s1 := [][]byte{[]byte("\x01\x00\x00\x80\x02\x00\xE0\x04\x23\x00\x00\x00\xF0\xEA\x10\x55\x42\x5A\x68\x39\x31\x41\x59\x26\x53\x59\x5B\x42\x68\x3D\x00\x00\x11\xCF\x80\xD0\x00\x48\x00\x02\x00\x00\x00\xAA\x66\x4D\x00\x00\x00\xA0\x00\x22\x21\xA6"), []byte("\x01\x00\x00\x80\x02\x01\xE0\x04\x80\x69\xA7\xEA\x85\x34\xC8\xC4\xC4\xC4\xEC\x0C\xD3\xBD\xAF\x34\x07\xDF\x48\x71\x6C\x26\x95\xA8\xB8\x02\x4D\xF1\x77\x24\x53\x85\x09\x05\xB4\x26\x83\xD0")}
+	expectation := "\xFF\xFF\xFF\xFF\x45\x02\x00sv_cheats\x000\x00mp_timelimit\x0030\x00"
+
+	splitPackets := MakeA2SSplitPacketCollection()
+	var result []byte
+	var complete bool
+	for _, fragment := range s1 {
+		var resultErr error
+		result, complete, resultErr = splitPackets.Add("127.0.0.1:27015", fragment, false)
+		if resultErr != nil {
+			t.Errorf(resultErr.Error())
+		}
+	}
+
+	if !complete || string(result) != expectation {
+		t.Errorf(ErrorOut(expectation, string(result)))
+	}
+}
+
+func TestA2SSplitResponseTracking(t *testing.T) {
+	s1 := [][]byte{[]byte("\xFF\xFF\xFF\xFE\x03\x00\x00\x00\x02\x00\xE0\x04\xFF\xFF\xFF\xFF\x45\x01\x00"), []byte("\xFF\xFF\xFF\xFE\x03\x00\x00\x00\x02\x01\xE0\x04sv_cheats\x000\x00")}
+	expectation := "A2S_RULES"
+
+	protocol := A2SMakeProtocolTemplate()
+	protocol.Base.PrepareQueryFunc(&protocol.Base)
+	identifyHandler := func(packet Packet) string {
+		return protocol.Base.ResponseIdFunc(packet, protocol.Information)
+	}
+
+	sent := time.Now()
+	tracker := MakeRequestTracker(nil, nil)
+	tracker.Queue(Packet{Id: "A2S_RULES", RemoteAddr: "127.0.0.1:27015"})
+	tracker.Sent(Packet{Id: "A2S_RULES", RemoteAddr: "127.0.0.1:27015"}, sent)
+
+	var result string
+	for _, data := range s1 {
+		if packet, _, matched := trackResponse(tracker, identifyHandler, Packet{Data: data, RemoteAddr: "127.0.0.1:27015", ReceiveTime: sent.Add(10 * time.Millisecond)}); matched {
+			result = packet.Id
+		}
+	}
+
+	if result != expectation {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+
+	if due := tracker.Due(sent.Add(time.Minute), func(Packet) (int, time.Duration) { return 2, time.Second }); len(due) != 0 {
+		t.Errorf(ErrorOut([]Packet{}, due))
+	}
+}
+
+func TestA2SSplitPacketCollectionOversized(t *testing.T) {
+	s1 := [][]byte{[]byte("\x01\x00\x00\x80\x02\x00\xE0\x04\x10\x00\x00\x00\xF0\xEA\x10\x55\x42\x5A\x68\x39\x31\x41\x59\x26\x53\x59\x5B\x42\x68\x3D\x00\x00\x11\xCF\x80\xD0\x00\x48\x00\x02\x00\x00\x00\xAA\x66\x4D\x00\x00\x00\xA0\x00\x22\x21\xA6"), []byte("\x01\x00\x00\x80\x02\x01\xE0\x04\x80\x69\xA7\xEA\x85\x34\xC8\xC4\xC4\xC4\xEC\x0C\xD3\xBD\xAF\x34\x07\xDF\x48\x71\x6C\x26\x95\xA8\xB8\x02\x4D\xF1\x77\x24\x53\x85\x09\x05\xB4\x26\x83\xD0")}
+	expectation := InvalidResponseLength
+
+	splitPackets := MakeA2SSplitPacketCollection()
+	var result error
+	for _, fragment := range s1 {
+		_, _, result = splitPackets.Add("127.0.0.1:27015", fragment, false)
+	}
+
+	if result != expectation {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
@@ -0,0 +1,199 @@
+package grokstat
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const GS1_SPLIT_PACKET_EXPIRY = 30 * time.Second
+
+func GS1MakeProtocolTemplate() ProtocolEntry {
+	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: MakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "status"}}, PrepareQueryFunc: GS1PrepareQuery, ResponseIdFunc: GS1ResponseId, ResponseMatchFunc: GS1MatchResponse, HttpProtocol: "udp", ResponseType: "Server info"}, Information: ProtocolEntryInfo{"Name": "GameSpy Server", "RequestPreludeTemplate": "\\status\\", "PlayerNameField": "player_", "TeamFields": "teamname_,teamscore_,teamcolor_,teamsize_", "ServerNameRule": "hostname", "TerrainRule": "mapname", "GameTypeRule": "gametype", "NeedPassRule": "password", "NumClientsRule": "numplayers", "MaxClientsRule": "maxplayers", "DefaultRequestPort": "7778"}}
+}
+
+// Sets up the handler for one query. The packets of split responses are kept for the hosts queried by it.
+func GS1PrepareQuery(base *ProtocolEntryBase) {
+	splitPackets := MakeGS1SplitPacketCollection()
+	base.HandlerFunc = func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+		return GS1Handler(splitPackets, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	}
+}
+
+// Splits the backslash separated response into key-value pairs in their original order.
+func GS1ParseKeyValues(b []byte) [][2]string {
+	pairs := [][2]string{}
+	fields := strings.Split(strings.TrimPrefix(string(b), "\\"), "\\")
+	for i := 0; i+1 < len(fields); i += 2 {
+		pairs = append(pairs, [2]string{fields[i], fields[i+1]})
+	}
+	if len(fields)%2 != 0 && fields[len(fields)-1] == "final" {
+		pairs = append(pairs, [2]string{"final", ""})
+	}
+	return pairs
+}
+
+// Returns the query id, the packet number and whether the packet is the last one. Packets without the query id are numbered 1.
+func gs1PacketNumber(pairs [][2]string) (queryId string, number int, final bool) {
+	number = 1
+	for _, pair := range pairs {
+		switch pair[0] {
+		case "queryid":
+			idNumber := strings.SplitN(pair[1], ".", 2)
+			queryId = idNumber[0]
+			if len(idNumber) == 2 {
+				number, _ = strconv.Atoi(idNumber[1])
+			}
+		case "final":
+			final = true
+		}
+	}
+	return queryId, number, final
+}
+
+// Returns "status" for the packet flagged as final, the other packets of the response are not matched to the request.
+func GS1ResponseId(packet Packet, protocolInfo ProtocolEntryInfo) string {
+	if _, _, final := gs1PacketNumber(GS1ParseKeyValues(packet.Data)); final {
+		return "status"
+	}
+	return "GS1_SPLIT"
+}
+
+// Matches the backslash separated responses carrying the query id or the final flag.
+func GS1MatchResponse(packet Packet, protocolInfo ProtocolEntryInfo) bool {
+	return bytes.HasPrefix(packet.Data, []byte("\\")) && (bytes.Contains(packet.Data, []byte("\\queryid\\")) || bytes.Contains(packet.Data, []byte("\\final\\")))
+}
+
+// Parses the response once all of its packets have arrived.
+func GS1Handler(splitPackets *GS1SplitPacketCollection, packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+	sendPackets = []Packet{}
+
+	protocolId := packet.ProtocolId
+	remoteIp := packet.RemoteAddr
+
+	pairs, complete, err := splitPackets.Add(remoteIp, GS1ParseKeyValues(packet.Data))
+	if err != nil {
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, err.Error())}
+		return sendPackets
+	}
+	if !complete {
+		return sendPackets
+	}
+	return SimpleReceiveHandler(func(p Packet, info ProtocolEntryInfo) (ServerEntry, error) {
+		return GS1parseKeyValues(pairs, info)
+	}, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+}
+
+type gs1SplitPacket struct {
+	queryId   string
+	total     int
+	fragments map[int][][2]string
+	updated   time.Time
+}
+
+// Collects the packets of the responses keyed by remote address. The last packet carries the final flag, so the total is known once it arrives.
+type GS1SplitPacketCollection struct {
+	sync.Mutex
+	data map[string]*gs1SplitPacket
+}
+
+func MakeGS1SplitPacketCollection() *GS1SplitPacketCollection {
+	return &GS1SplitPacketCollection{data: map[string]*gs1SplitPacket{}}
+}
+
+// Stores the key-value pairs of the packet and returns the pairs of all packets in order, without the query id and final flag, once the response is complete.
+// Packets of a different query id replace the incomplete response.
+func (c *GS1SplitPacketCollection) Add(remoteAddr string, pairs [][2]string) (result [][2]string, complete bool, err error) {
+	queryId, number, final := gs1PacketNumber(pairs)
+	if number < 1 {
+		return nil, false, MalformedPacket
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	for k, v := range c.data {
+		if now.Sub(v.updated) > GS1_SPLIT_PACKET_EXPIRY {
+			delete(c.data, k)
+		}
+	}
+
+	splitPacket, exists := c.data[remoteAddr]
+	if !exists || splitPacket.queryId != queryId {
+		splitPacket = &gs1SplitPacket{queryId: queryId, fragments: map[int][][2]string{}}
+		c.data[remoteAddr] = splitPacket
+	}
+	splitPacket.updated = now
+	if final {
+		splitPacket.total = number
+	}
+	splitPacket.fragments[number] = pairs
+
+	if splitPacket.total == 0 || len(splitPacket.fragments) < splitPacket.total {
+		return nil, false, nil
+	}
+	delete(c.data, remoteAddr)
+
+	for i := 1; i <= splitPacket.total; i++ {
+		fragment, exists := splitPacket.fragments[i]
+		if !exists {
+			return nil, false, MalformedPacket
+		}
+		for _, pair := range fragment {
+			if pair[0] != "queryid" && pair[0] != "final" {
+				result = append(result, pair)
+			}
+		}
+	}
+	return result, true, nil
+}
+
+// Splits the key into the field name and the player index, for example player_3 into player_ and 3.
+func gs1PlayerField(key string) (field string, index int, ok bool) {
+	separator := strings.LastIndex(key, "_")
+	if separator < 0 {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(key[separator+1:])
+	if err != nil || index < 0 {
+		return "", 0, false
+	}
+	return key[:separator+1], index, true
+}
+
+// Parses the key-value pairs of the response. Player fields are the keys following the player name field which carry its index, such as frags_3 after player_3.
+// The other keys are rules, as are the fields listed comma separated in the TeamFields protocol information key, such as teamname_ for teamname_0.
+func GS1parseKeyValues(pairs [][2]string, protocolInfo ProtocolEntryInfo) (entry ServerEntry, err error) {
+	if len(pairs) == 0 {
+		return MakeServerEntry(), InvalidRuleString
+	}
+	nameField := protocolInfo["PlayerNameField"]
+	teamFields := strings.Split(protocolInfo["TeamFields"], ",")
+
+	entry = MakeServerEntry()
+	playerFields := map[string]map[int]string{}
+	playerIndex := -1
+	for _, pair := range pairs {
+		field, index, ok := gs1PlayerField(pair[0])
+		if ok && field == nameField {
+			playerIndex = index
+		} else if !ok || index != playerIndex || containsString(teamFields, field) {
+			playerIndex = -1
+			entry.Rules[pair[0]] = pair[1]
+			continue
+		}
+		if _, exists := playerFields[field]; !exists {
+			playerFields[field] = map[int]string{}
+		}
+		playerFields[field][index] = pair[1]
+	}
+	entry.Players = GameSpyMakePlayers(playerFields, nameField)
+	entry.NumClients = int64(len(entry.Players))
+	ApplyRuleMappings(&entry, entry.Rules, protocolInfo)
+
+	return entry, nil
+}
@@ -0,0 +1,62 @@
+package grokstat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGS1SplitPacketCollection(t *testing.T) {
+	s1 := []string{"\\player_1\\Stat\\frags_1\\3\\ping_1\\80\\final\\\\queryid\\42.2", "\\hostname\\Grok UT\\mapname\\DM-Deck16][\\gametype\\DeathMatchPlus\\numplayers\\2\\maxplayers\\16\\password\\false\\queryid\\42.1\\player_0\\Grok\\frags_0\\10\\ping_0\\40"}
+	info := ProtocolEntryInfo{"PlayerNameField": "player_", "ServerNameRule": "hostname", "TerrainRule": "mapname", "GameTypeRule": "gametype", "NeedPassRule": "password", "NumClientsRule": "numplayers", "MaxClientsRule": "maxplayers"}
+	expectation := ServerEntry{Name: "Grok UT", Terrain: "DM-Deck16][", GameType: "DeathMatchPlus", NumClients: 2, MaxClients: 16, Players: []PlayerEntry{{Name: "Grok", Ping: 40, Info: map[string]string{"frags": "10"}}, {Name: "Stat", Ping: 80, Info: map[string]string{"frags": "3"}}}, Rules: map[string]string{"hostname": "Grok UT", "mapname": "DM-Deck16][", "gametype": "DeathMatchPlus", "numplayers": "2", "maxplayers": "16", "password": "false"}}
+
+	splitPackets := MakeGS1SplitPacketCollection()
+	var pairs [][2]string
+	var complete bool
+	for i, packet := range s1 {
+		var resultErr error
+		pairs, complete, resultErr = splitPackets.Add("127.0.0.1:7778", GS1ParseKeyValues([]byte(packet)))
+		if resultErr != nil {
+			t.Errorf(resultErr.Error())
+		}
+		if complete != (i == len(s1)-1) {
+			t.Errorf(ErrorOut(i == len(s1)-1, complete))
+		}
+	}
+
+	result, resultErr := GS1parseKeyValues(pairs, info)
+	if resultErr != nil {
+		t.Fatal(resultErr)
+	}
+	if !reflect.DeepEqual(expectation, result) {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestGS1parseKeyValuesTeams(t *testing.T) {
+	s1 := "\\hostname\\Grok UT\\maxteams\\2\\teamname_0\\Red\\teamscore_0\\12\\player_0\\Grok\\frags_0\\10\\ping_0\\40\\team_0\\0\\player_1\\Stat\\frags_1\\3\\ping_1\\80\\team_1\\1\\teamname_1\\Blue\\teamscore_1\\7"
+	info := GS1MakeProtocolTemplate().Information
+	expectation := ServerEntry{Name: "Grok UT", NumClients: 2, Players: []PlayerEntry{{Name: "Grok", Ping: 40, Info: map[string]string{"frags": "10", "team": "0"}}, {Name: "Stat", Ping: 80, Info: map[string]string{"frags": "3", "team": "1"}}}, Rules: map[string]string{"hostname": "Grok UT", "maxteams": "2", "teamname_0": "Red", "teamscore_0": "12", "teamname_1": "Blue", "teamscore_1": "7"}}
+
+	result, resultErr := GS1parseKeyValues(GS1ParseKeyValues([]byte(s1)), info)
+	if resultErr != nil {
+		t.Fatal(resultErr)
+	}
+	if !reflect.DeepEqual(expectation, result) {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestGS1ResponseId(t *testing.T) {
+	s1 := []string{"\\hostname\\Grok\\queryid\\7.1", "\\hostname\\Grok\\final\\\\queryid\\7.1", "\\hostname\\Grok\\queryid\\7.1\\final\\"}
+	expectation := []string{"GS1_SPLIT", "status", "status"}
+
+	result := []string{}
+	for _, data := range s1 {
+		result = append(result, GS1ResponseId(Packet{Data: []byte(data)}, ProtocolEntryInfo{}))
+	}
+
+	if !reflect.DeepEqual(expectation, result) {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
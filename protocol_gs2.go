@@ -0,0 +1,89 @@
+package grokstat
+
+import (
+	"bytes"
+	"strconv"
+)
+
+func GS2MakeProtocolTemplate() ProtocolEntry {
+	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: MakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "status"}}, HandlerFunc: func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+		return SimpleReceiveHandler(GS2parsePacket, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	}, ResponseMatchFunc: GS2MatchResponse, HttpProtocol: "udp", ResponseType: "Server info"}, Information: ProtocolEntryInfo{"Name": "GameSpy2 Server", "SessionId": "\x05\x06\x07\x08", "RequestPreludeTemplate": "\xFE\xFD\x00{{.SessionId}}\xFF\xFF\xFF", "ServerNameRule": "hostname", "TerrainRule": "mapname", "GameTypeRule": "gametype", "NeedPassRule": "password", "NumClientsRule": "numplayers", "MaxClientsRule": "maxplayers", "DefaultRequestPort": "2302"}}
+}
+
+// Matches the responses echoing the session id.
+func GS2MatchResponse(packet Packet, protocolInfo ProtocolEntryInfo) bool {
+	_, ok := CheckPrelude(packet.Data, []byte("\x00"+protocolInfo["SessionId"]))
+	return ok
+}
+
+// Reads the player or team section: the item count, the field names ending with an empty name and the values of each item.
+func gs2ParseSection(buf *bytes.Buffer) map[string]map[int]string {
+	fields := map[string]map[int]string{}
+	count, countErr := buf.ReadByte()
+	if countErr != nil {
+		return fields
+	}
+	names := []string{}
+	for {
+		name, nameOk := readGS4String(buf)
+		if !nameOk || name == "" {
+			break
+		}
+		names = append(names, name)
+		fields[name] = map[int]string{}
+	}
+	for i := 0; i < int(count); i++ {
+		for _, name := range names {
+			value, valueOk := readGS4String(buf)
+			if !valueOk {
+				return fields
+			}
+			fields[name][i] = value
+		}
+	}
+	return fields
+}
+
+// Parses the response: null-terminated rules ending with an empty key, followed by the player and team sections. Team fields are stored as rules suffixed with the team index.
+func GS2parsePacket(packet Packet, protocolInfo ProtocolEntryInfo) (entry ServerEntry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			entry = MakeServerEntry()
+			err = MalformedPacket
+		}
+	}()
+	body, preludeOk := CheckPrelude(packet.Data, []byte("\x00"+protocolInfo["SessionId"]))
+	if !preludeOk {
+		return MakeServerEntry(), InvalidResponseHeader
+	}
+
+	entry = MakeServerEntry()
+	buf := bytes.NewBuffer(body)
+	for {
+		key, keyOk := readGS4String(buf)
+		if !keyOk {
+			return MakeServerEntry(), InvalidRuleString
+		}
+		if key == "" {
+			break
+		}
+		value, valueOk := readGS4String(buf)
+		if !valueOk {
+			return MakeServerEntry(), InvalidRuleString
+		}
+		entry.Rules[key] = value
+	}
+
+	entry.Players = GameSpyMakePlayers(gs2ParseSection(buf), "player_")
+	for field, values := range gs2ParseSection(buf) {
+		for i, value := range values {
+			entry.Rules[field+strconv.Itoa(i)] = value
+		}
+	}
+	entry.Ping = packet.Ping
+	entry.NumClients = int64(len(entry.Players))
+	ApplyRuleMappings(&entry, entry.Rules, protocolInfo)
+
+	return entry, nil
+}
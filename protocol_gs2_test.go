@@ -0,0 +1,20 @@
+package grokstat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGS2parsePacket(t *testing.T) {
+	s1 := []byte("\x00\x05\x06\x07\x08hostname\x00Grok Halo\x00mapname\x00bloodgulch\x00numplayers\x002\x00maxplayers\x0016\x00password\x000\x00\x00\x02player_\x00score_\x00ping_\x00\x00Grok\x005\x0040\x00Stat\x001\x0060\x00\x02team_t\x00score_t\x00\x00Red\x006\x00Blue\x000\x00")
+	info := GS2MakeProtocolTemplate().Information
+	expectation := ServerEntry{Name: "Grok Halo", Terrain: "bloodgulch", NumClients: 2, MaxClients: 16, Players: []PlayerEntry{{Name: "Grok", Ping: 40, Info: map[string]string{"score": "5"}}, {Name: "Stat", Ping: 60, Info: map[string]string{"score": "1"}}}, Rules: map[string]string{"hostname": "Grok Halo", "mapname": "bloodgulch", "numplayers": "2", "maxplayers": "16", "password": "0", "team_t0": "Red", "team_t1": "Blue", "score_t0": "6", "score_t1": "0"}}
+
+	result, resultErr := GS2parsePacket(Packet{Data: s1}, info)
+	if resultErr != nil {
+		t.Fatal(resultErr)
+	}
+	if !reflect.DeepEqual(expectation, result) {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
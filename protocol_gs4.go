@@ -0,0 +1,347 @@
+package grokstat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	GS4_FULLSTAT_RESPONSE   = 0x00
+	GS4_CHALLENGE_RESPONSE  = 0x09
+	GS4_SECTION_RULES       = 0x00
+	GS4_SECTION_PLAYERS     = 0x01
+	GS4_SECTION_TEAMS       = 0x02
+	GS4_SPLIT_PACKET_LAST   = 0x80
+	GS4_SPLIT_PACKET_EXPIRY = 30 * time.Second
+)
+
+var gs4SplitNum = []byte("splitnum\x00")
+
+func GS4MakeProtocolTemplate() ProtocolEntry {
+	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: GS4MakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "challenge"}}, PrepareQueryFunc: GS4PrepareQuery, ResponseIdFunc: GS4ResponseId, ResponseMatchFunc: GS4MatchResponse, HttpProtocol: "udp", ResponseType: "Server info"}, Information: ProtocolEntryInfo{"Name": "GameSpy4 Server", "SessionId": "\x01\x02\x03\x04", "ChallengeRequired": "true", "ServerNameRule": "hostname", "TerrainRule": "mapname", "GameTypeRule": "gametype", "ModNameRule": "gamevariant", "NeedPassRule": "password", "NumClientsRule": "numplayers", "MaxClientsRule": "maxplayers", "DefaultRequestPort": "29900"}}
+}
+
+// Sets up the handler for one query. The fragments of split responses are kept for the hosts queried by it.
+func GS4PrepareQuery(base *ProtocolEntryBase) {
+	splitPackets := MakeGS4SplitPacketCollection()
+	base.HandlerFunc = func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+		return GS4Handler(splitPackets, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	}
+}
+
+// Builds the full stat request asking for the rules, players and teams. Servers which do not require a challenge are sent the request without the token.
+func makeGS4FullStatRequest(challenge []byte, protocolInfo ProtocolEntryInfo) []byte {
+	data := append([]byte("\xFE\xFD\x00"), protocolInfo["SessionId"]...)
+	data = append(data, challenge...)
+	return append(data, "\xFF\xFF\xFF\x01"...)
+}
+
+func GS4MakePayload(packet Packet, protocolInfo ProtocolEntryInfo) Packet {
+	if protocolInfo["ChallengeRequired"] == "false" {
+		packet.Id = "fullstat"
+		packet.Data = makeGS4FullStatRequest(nil, protocolInfo)
+		return packet
+	}
+	packet.Data = append([]byte("\xFE\xFD\x09"), protocolInfo["SessionId"]...)
+	return packet
+}
+
+// Returns the type of the response and its body following the session id. Fails if the session id does not match.
+func gs4ResponseBody(packet Packet, protocolInfo ProtocolEntryInfo) (responseType byte, body []byte, ok bool) {
+	sessionId := []byte(protocolInfo["SessionId"])
+	if len(packet.Data) < 1+len(sessionId) || !bytes.Equal(packet.Data[1:1+len(sessionId)], sessionId) {
+		return 0, nil, false
+	}
+	return packet.Data[0], packet.Data[1+len(sessionId):], true
+}
+
+// Returns the id of the request the response answers. Only the last fragment of a full stat response is matched to the request.
+func GS4ResponseId(packet Packet, protocolInfo ProtocolEntryInfo) string {
+	responseType, body, ok := gs4ResponseBody(packet, protocolInfo)
+	if !ok {
+		return ""
+	}
+	switch responseType {
+	case GS4_CHALLENGE_RESPONSE:
+		return "challenge"
+	case GS4_FULLSTAT_RESPONSE:
+		if splitBody, isSplit := CheckPrelude(body, gs4SplitNum); isSplit && len(splitBody) > 0 && splitBody[0]&GS4_SPLIT_PACKET_LAST == 0 {
+			return "GS4_SPLIT"
+		}
+		return "fullstat"
+	default:
+		return ""
+	}
+}
+
+// Matches the challenge and full stat responses echoing the session id.
+func GS4MatchResponse(packet Packet, protocolInfo ProtocolEntryInfo) bool {
+	responseType, _, ok := gs4ResponseBody(packet, protocolInfo)
+	return ok && (responseType == GS4_CHALLENGE_RESPONSE || responseType == GS4_FULLSTAT_RESPONSE)
+}
+
+// Answers the challenge with the full stat request and parses the full stat response once all of its fragments have arrived.
+func GS4Handler(splitPackets *GS4SplitPacketCollection, packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+	sendPackets = []Packet{}
+
+	protocolId := packet.ProtocolId
+	protocol, protocolExists := protocolCollection.Get(protocolId)
+	if !protocolExists {
+		return sendPackets
+	}
+	protocolInfo := protocol.Information
+	remoteIp := packet.RemoteAddr
+
+	responseType, body, ok := gs4ResponseBody(packet, protocolInfo)
+	if !ok {
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, InvalidResponseHeader.Error())}
+		serverEntryChan <- MakeErrorServerEntry(remoteIp, protocolId, InvalidResponseHeader)
+		return sendPackets
+	}
+
+	switch responseType {
+	case GS4_CHALLENGE_RESPONSE:
+		challenge, err := strconv.ParseInt(string(bytes.TrimRight(body, "\x00")), 10, 64)
+		if err != nil {
+			messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, InvalidResponseChallenge.Error())}
+			serverEntryChan <- MakeErrorServerEntry(remoteIp, protocolId, InvalidResponseChallenge)
+			return sendPackets
+		}
+		challengeBytes := make([]byte, 4)
+		binary.BigEndian.PutUint32(challengeBytes, uint32(challenge))
+		messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - Received challenge %s.", protocolId, remoteIp, GetByteString(challengeBytes))}
+		sendPackets = append(sendPackets, Packet{Id: "fullstat", Type: packet.Type, RemoteAddr: remoteIp, ProtocolId: protocolId, Data: makeGS4FullStatRequest(challengeBytes, protocolInfo)})
+		return sendPackets
+	case GS4_FULLSTAT_RESPONSE:
+		fragments, complete, err := splitPackets.Add(remoteIp, body)
+		if err != nil {
+			messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, err.Error())}
+			return sendPackets
+		}
+		if !complete {
+			return sendPackets
+		}
+		if len(fragments) > 1 {
+			messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - Reassembled %d fragments of split response.", protocolId, remoteIp, len(fragments))}
+		}
+		return SimpleReceiveHandler(func(p Packet, info ProtocolEntryInfo) (ServerEntry, error) {
+			return GS4parseFragments(fragments, info)
+		}, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	default:
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, InvalidServerHeader.Error())}
+		serverEntryChan <- MakeErrorServerEntry(remoteIp, protocolId, InvalidServerHeader)
+		return sendPackets
+	}
+}
+
+type gs4SplitPacket struct {
+	total     int
+	fragments map[int][]byte
+	updated   time.Time
+}
+
+// Collects fragments of full stat responses keyed by remote address. The last fragment is flagged, so the total is known once it arrives.
+type GS4SplitPacketCollection struct {
+	sync.Mutex
+	data map[string]*gs4SplitPacket
+}
+
+func MakeGS4SplitPacketCollection() *GS4SplitPacketCollection {
+	return &GS4SplitPacketCollection{data: map[string]*gs4SplitPacket{}}
+}
+
+// Stores the fragment (with the type and session id already stripped) and returns the fragment bodies in order once all of them have arrived.
+// Responses without the split number are returned as the only fragment.
+func (c *GS4SplitPacketCollection) Add(remoteAddr string, data []byte) (fragments [][]byte, complete bool, err error) {
+	body, isSplit := CheckPrelude(data, gs4SplitNum)
+	if !isSplit {
+		return [][]byte{data}, true, nil
+	}
+	if len(body) == 0 {
+		return nil, false, InvalidResponseLength
+	}
+	number := int(body[0] &^ GS4_SPLIT_PACKET_LAST)
+	last := body[0]&GS4_SPLIT_PACKET_LAST != 0
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	for k, v := range c.data {
+		if now.Sub(v.updated) > GS4_SPLIT_PACKET_EXPIRY {
+			delete(c.data, k)
+		}
+	}
+
+	splitPacket, exists := c.data[remoteAddr]
+	if !exists {
+		splitPacket = &gs4SplitPacket{fragments: map[int][]byte{}}
+		c.data[remoteAddr] = splitPacket
+	}
+	splitPacket.updated = now
+	if last {
+		splitPacket.total = number + 1
+	}
+	fragment := make([]byte, len(body)-1)
+	copy(fragment, body[1:])
+	splitPacket.fragments[number] = fragment
+
+	if splitPacket.total == 0 || len(splitPacket.fragments) < splitPacket.total {
+		return nil, false, nil
+	}
+	delete(c.data, remoteAddr)
+
+	for i := 0; i < splitPacket.total; i++ {
+		fragment, exists := splitPacket.fragments[i]
+		if !exists {
+			return nil, false, MalformedPacket
+		}
+		fragments = append(fragments, fragment)
+	}
+	return fragments, true, nil
+}
+
+func readGS4String(buf *bytes.Buffer) (string, bool) {
+	b, err := buf.ReadBytes(0)
+	if err != nil {
+		return string(b), false
+	}
+	return string(b[:len(b)-1]), true
+}
+
+// Reports whether an empty value read from the field value list is its terminator, that is whether the data ends, the section ends or the next field follows.
+func gs4ValuesEnd(buf *bytes.Buffer) bool {
+	rest := buf.Bytes()
+	end := bytes.IndexByte(rest, 0)
+	if end <= 0 {
+		return true
+	}
+	field := string(rest[:end])
+	return strings.HasSuffix(field, "_") || strings.HasSuffix(field, "_t")
+}
+
+// Parses the sections of a full stat fragment. Rules are key-value pairs, the player and team sections list the values of each field starting at the offset.
+// Sections cut off at the end of the fragment are continued in the next one. Player values are read up to the player count in the numClientsRule rule if known, so empty values do not end the list early.
+func GS4parseFragment(b []byte, rules map[string]string, players map[string]map[int]string, teams map[string]map[int]string, numClientsRule string) {
+	buf := bytes.NewBuffer(b)
+	for buf.Len() > 0 {
+		section, _ := buf.ReadByte()
+		switch section {
+		case GS4_SECTION_RULES:
+			for {
+				key, keyOk := readGS4String(buf)
+				if !keyOk || key == "" {
+					break
+				}
+				value, valueOk := readGS4String(buf)
+				if !valueOk {
+					break
+				}
+				rules[key] = value
+			}
+		case GS4_SECTION_PLAYERS, GS4_SECTION_TEAMS:
+			fields, count := players, -1
+			if section == GS4_SECTION_TEAMS {
+				fields = teams
+			} else if numClients, numClientsErr := strconv.Atoi(rules[numClientsRule]); numClientsErr == nil {
+				count = numClients
+			}
+			for {
+				field, fieldOk := readGS4String(buf)
+				if !fieldOk || field == "" {
+					break
+				}
+				offset, offsetErr := buf.ReadByte()
+				if offsetErr != nil {
+					break
+				}
+				if _, exists := fields[field]; !exists {
+					fields[field] = map[int]string{}
+				}
+				for i := int(offset); ; i++ {
+					value, valueOk := readGS4String(buf)
+					if !valueOk {
+						break
+					}
+					if value == "" {
+						if (count >= 0 && i >= count) || (count < 0 && gs4ValuesEnd(buf)) {
+							break
+						}
+						continue
+					}
+					fields[field][i] = value
+				}
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Makes the player entries from the player field values keyed by the player index. Only the players with a name are listed.
+// Fields other than the name and ping are stored in the player info without the trailing underscore.
+func GameSpyMakePlayers(fields map[string]map[int]string, nameField string) []PlayerEntry {
+	indexes := []int{}
+	for i := range fields[nameField] {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	players := []PlayerEntry{}
+	for _, i := range indexes {
+		player := MakePlayerEntry()
+		player.Name = fields[nameField][i]
+		for field, values := range fields {
+			value, exists := values[i]
+			if !exists || field == nameField {
+				continue
+			}
+			if field == "ping_" {
+				player.Ping, _ = strconv.ParseInt(value, 10, 64)
+			} else {
+				player.Info[strings.TrimSuffix(field, "_")] = value
+			}
+		}
+		players = append(players, player)
+	}
+	return players
+}
+
+// Parses the full stat fragments. Team fields are stored as rules suffixed with the team index.
+func GS4parseFragments(fragments [][]byte, protocolInfo ProtocolEntryInfo) (entry ServerEntry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			entry = MakeServerEntry()
+			err = MalformedPacket
+		}
+	}()
+	rules := map[string]string{}
+	playerFields := map[string]map[int]string{}
+	teamFields := map[string]map[int]string{}
+	for _, fragment := range fragments {
+		GS4parseFragment(fragment, rules, playerFields, teamFields, protocolInfo["NumClientsRule"])
+	}
+	if len(rules) == 0 {
+		return MakeServerEntry(), InvalidRuleString
+	}
+
+	for field, values := range teamFields {
+		for i, value := range values {
+			rules[field+strconv.Itoa(i)] = value
+		}
+	}
+
+	entry = MakeServerEntry()
+	entry.Players = GameSpyMakePlayers(playerFields, "player_")
+	entry.Rules = rules
+	entry.NumClients = int64(len(entry.Players))
+	ApplyRuleMappings(&entry, rules, protocolInfo)
+
+	return entry, nil
+}
@@ -0,0 +1,117 @@
+package grokstat
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGS4parseFragments(t *testing.T) {
+	s1 := [][]byte{[]byte("\x00hostname\x00A Minecraft Server\x00gametype\x00SMP\x00game_id\x00MINECRAFT\x00map\x00world\x00numplayers\x002\x00maxplayers\x0020\x00\x00\x01player_\x00\x00Notch\x00jeb_\x00\x00")}
+	info := ProtocolEntryInfo{"ServerNameRule": "hostname", "TerrainRule": "map", "GameTypeRule": "gametype", "ModNameRule": "game_id", "NumClientsRule": "numplayers", "MaxClientsRule": "maxplayers"}
+	expectation := fmt.Sprint("A Minecraft Server world SMP MINECRAFT ", 2, 20, " [Notch jeb_]")
+
+	result, resultErr := GS4parseFragments(s1, info)
+	if resultErr != nil {
+		t.Fatal(resultErr)
+	}
+
+	names := []string{}
+	for _, player := range result.Players {
+		names = append(names, player.Name)
+	}
+	if fmt.Sprint(result.Name, " ", result.Terrain, " ", result.GameType, " ", result.ModName, " ", result.NumClients, result.MaxClients, " ", names) != expectation {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestGS4parseFragmentsEmptyValues(t *testing.T) {
+	s1 := [][]byte{[]byte("\x00hostname\x00Grok\x00numplayers\x003\x00\x00\x01player_\x00\x00Grok\x00Stat\x00jeb_\x00\x00score_\x00\x0010\x00\x0030\x00\x00\x00\x02team_t\x00\x00Red\x00\x00Blue\x00\x00score_t\x00\x001\x00\x003\x00\x00\x00")}
+	info := ProtocolEntryInfo{"ServerNameRule": "hostname", "NumClientsRule": "numplayers"}
+	expectation := "[Grok 10] [Stat ] [jeb_ 30] Red  Blue 1  3"
+
+	result, resultErr := GS4parseFragments(s1, info)
+	if resultErr != nil {
+		t.Fatal(resultErr)
+	}
+
+	players := []string{}
+	for _, player := range result.Players {
+		players = append(players, fmt.Sprint([]string{player.Name, player.Info["score"]}))
+	}
+	if strings.Join(players, " ")+" "+strings.Join([]string{result.Rules["team_t0"], result.Rules["team_t1"], result.Rules["team_t2"], result.Rules["score_t0"], result.Rules["score_t1"], result.Rules["score_t2"]}, " ") != expectation {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestGS4SplitPacketCollection(t *testing.T) {
+	s1 := [][]byte{[]byte("splitnum\x00\x81\x01player_\x00\x01Stat\x00\x00ping_\x00\x0150\x00\x00\x00"), []byte("splitnum\x00\x00\x00hostname\x00Grok\x00\x00\x01player_\x00\x00Grok\x00\x00ping_\x00\x0040\x00\x00\x00\x02team_t\x00\x00Red\x00Blue\x00\x00\x00")}
+	expectation := []PlayerEntry{PlayerEntry{Name: "Grok", Ping: 40, Info: map[string]string{}}, PlayerEntry{Name: "Stat", Ping: 50, Info: map[string]string{}}}
+
+	splitPackets := MakeGS4SplitPacketCollection()
+	var fragments [][]byte
+	var complete bool
+	for _, fragment := range s1 {
+		var resultErr error
+		fragments, complete, resultErr = splitPackets.Add("127.0.0.1:29900", fragment)
+		if resultErr != nil {
+			t.Errorf(resultErr.Error())
+		}
+	}
+	if !complete {
+		t.Fatalf(ErrorOut(true, complete))
+	}
+
+	result, resultErr := GS4parseFragments(fragments, ProtocolEntryInfo{"ServerNameRule": "hostname"})
+	if resultErr != nil {
+		t.Fatal(resultErr)
+	}
+	if !reflect.DeepEqual(expectation, result.Players) || result.Name != "Grok" || result.Rules["team_t1"] != "Blue" {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestQueryGS4(t *testing.T) {
+	conn, lErr := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			request := buf[:n]
+			switch {
+			case n == 7 && request[2] == 0x09:
+				conn.WriteToUDP(append(append([]byte{0x09}, request[3:7]...), "-1234567\x00"...), addr)
+			case n == 15 && request[2] == 0x00 && string(request[7:11]) == "\xFF\xED\x29\x79":
+				conn.WriteToUDP(append(append([]byte{0x00}, request[3:7]...), "splitnum\x00\x80\x00hostname\x00Stub\x00numplayers\x000\x00maxplayers\x008\x00\x00\x01player_\x00\x00\x00"...), addr)
+			}
+		}
+	}()
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "gs4", Template: "GS4"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: conn.LocalAddr().String(), ProtocolId: "gs4"}}
+	expectation := fmt.Sprint(200, " Stub ", 0, 8)
+
+	result, err := Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Servers) != 1 {
+		t.Fatalf(ErrorOut(expectation, result.Servers))
+	}
+	entry := result.Servers[0]
+	if fmt.Sprint(entry.Status, " ", entry.Name, " ", entry.NumClients, entry.MaxClients) != expectation {
+		t.Errorf(ErrorOut(expectation, entry))
+	}
+}
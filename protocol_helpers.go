@@ -1,8 +1,12 @@
-package main
+package grokstat
 
 import (
 	"bytes"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func MakeRequestPacket(packetId string, protocolInfo ProtocolEntryInfo) (requestPacket Packet) {
@@ -31,6 +35,7 @@ func SimpleReceiveHandler(parseFunc func(Packet, ProtocolEntryInfo) (ServerEntry
 
 	if sErr != nil {
 		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, sErr.Error())}
+		serverEntryChan <- MakeErrorServerEntry(remoteIp, protocolId, sErr)
 		return sendPackets
 	}
 
@@ -59,6 +64,7 @@ func MasterReceiveHandler(parseFunc func(Packet, ProtocolEntryInfo) ([]string, e
 
 	if err != nil {
 		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s.", protocolId, remoteIp, err.Error())}
+		serverEntryChan <- MakeErrorServerEntry(remoteIp, protocolId, err)
 		return sendPackets
 	}
 
@@ -87,11 +93,12 @@ func MakeSendPackets(pair HostProtocolIdPair, protocolCollection *ProtocolCollec
 	protocolId := pair.ProtocolId
 	if protocol, exists := protocolCollection.Get(protocolId); exists {
 		requestPackets := protocol.Base.RequestPackets
+		packetType := MakePacketType(protocol.Base.HttpProtocol, remoteAddr)
 		for _, reqPacketDesc := range requestPackets {
 			packetId := reqPacketDesc.Id
 			makePayloadFunc := protocol.Base.MakePayloadFunc
 			if makePayloadFunc != nil {
-				newReqPacket := protocol.Base.MakePayloadFunc(Packet{Id: packetId, RemoteAddr: remoteAddr, ProtocolId: protocolId}, protocol.Information)
+				newReqPacket := protocol.Base.MakePayloadFunc(Packet{Id: packetId, Type: packetType, RemoteAddr: remoteAddr, ProtocolId: protocolId, Hostname: pair.Hostname}, protocol.Information)
 				sendPackets = append(sendPackets, newReqPacket)
 			}
 		}
@@ -99,8 +106,54 @@ func MakeSendPackets(pair HostProtocolIdPair, protocolCollection *ProtocolCollec
 	return sendPackets
 }
 
+// Returns the port the queries are sent to for the specified game port, shifted by the QueryPortOffset protocol information key if set.
+func QueryPort(port string, protocolInfo ProtocolEntryInfo) string {
+	offset, oErr := strconv.Atoi(protocolInfo["QueryPortOffset"])
+	portNum, pErr := strconv.Atoi(port)
+	if oErr != nil || pErr != nil {
+		return port
+	}
+	return strconv.Itoa(portNum + offset)
+}
+
+// Returns the retry count and the initial backoff set with the Retries and RetryBackoff (milliseconds) protocol information keys. The backoff doubles with every attempt.
+func RetryPolicy(protocolInfo ProtocolEntryInfo) (retries int, backoff time.Duration) {
+	retries = DEFAULT_RETRIES
+	backoff = DEFAULT_RETRY_BACKOFF
+	if v, err := strconv.Atoi(protocolInfo["Retries"]); err == nil && v >= 0 {
+		retries = v
+	}
+	if v, err := strconv.Atoi(protocolInfo["RetryBackoff"]); err == nil && v > 0 {
+		backoff = time.Duration(v) * time.Millisecond
+	}
+	return retries, backoff
+}
+
+// Splits the remote address into host and port. The address may be a hostname, IPv4 or bracketed IPv6 address with optional port.
+func SplitRemoteAddr(remoteAddr string, defaultPort string) (host string, port string) {
+	var err error
+	host, port, err = net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(remoteAddr, "["), "]"), defaultPort
+	}
+	return host, port
+}
+
+// Matches the responses starting with the ResponsePreludeTemplate of the protocol.
+func MatchResponsePrelude(packet Packet, protocolInfo ProtocolEntryInfo) bool {
+	preludeTemplate := protocolInfo["ResponsePreludeTemplate"]
+	if preludeTemplate == "" {
+		return false
+	}
+	_, preludeOk := CheckPrelude(packet.Data, []byte(ParseTemplate(preludeTemplate, protocolInfo)))
+	return preludeOk
+}
+
 func CheckPrelude(data []byte, prelude []byte) (body []byte, rOk bool) {
-	rOk = bytes.Equal(data[:len(prelude)], prelude)
+	rOk = bytes.HasPrefix(data, prelude)
+	if !rOk {
+		return nil, rOk
+	}
 	body = data[len(prelude):]
 	return body, rOk
 }
@@ -140,3 +193,88 @@ var ParseBinaryIPv4Entry = func(entryRaw []byte, portLittleEndian bool) (string,
 
 	return serverEntry, nil
 }
+
+var ParseBinaryIPv6Entry = func(entryRaw []byte, portLittleEndian bool) (string, error) {
+	if len(entryRaw) != 18 {
+		return "", InvalidServerEntryInMasterResponse
+	}
+
+	ip := net.IP(entryRaw[:16])
+	var port int
+	if portLittleEndian {
+		port = int(entryRaw[17])<<8 | int(entryRaw[16])
+	} else {
+		port = int(entryRaw[16])<<8 | int(entryRaw[17])
+	}
+
+	if ip.IsUnspecified() {
+		return "", InvalidServerEntryInMasterResponse
+	}
+
+	serverEntry := net.JoinHostPort(ip.String(), fmt.Sprint(port))
+
+	return serverEntry, nil
+}
+
+// Fills in the server entry fields from the rules named by the ServerNameRule, TerrainRule and other *Rule protocol information keys. Fields without a rule mapping or rule value are left untouched.
+func ApplyRuleMappings(entry *ServerEntry, rules map[string]string, info ProtocolEntryInfo) {
+	serverNameRule, serverNameRuleOk := info["ServerNameRule"]
+	if serverNameRuleOk {
+		serverName, _ := rules[serverNameRule]
+		entry.Name = strings.TrimSpace(serverName)
+	}
+
+	needPassRule, needPassRuleOk := info["NeedPassRule"]
+	if needPassRuleOk {
+		needPass, _ := rules[needPassRule]
+		entry.NeedPass, _ = strconv.ParseBool(needPass)
+	}
+
+	terrainRule, terrainRuleOk := info["TerrainRule"]
+	if terrainRuleOk {
+		terrain, _ := rules[terrainRule]
+		entry.Terrain = strings.TrimSpace(terrain)
+	}
+
+	modNameRule, modNameRuleOk := info["ModNameRule"]
+	if modNameRuleOk {
+		modName, _ := rules[modNameRule]
+		entry.ModName = strings.TrimSpace(modName)
+	}
+
+	gameTypeRule, gameTypeRuleOk := info["GameTypeRule"]
+	if gameTypeRuleOk {
+		gameType, _ := rules[gameTypeRule]
+		entry.GameType = strings.TrimSpace(gameType)
+	}
+
+	secureRule, secureRuleOk := info["SecureRule"]
+	if secureRuleOk {
+		secure, _ := rules[secureRule]
+		entry.Secure, _ = strconv.ParseBool(secure)
+	}
+
+	numClientsRule, numClientsRuleOk := info["NumClientsRule"]
+	if numClientsRuleOk {
+		numClients, numClientsOk := rules[numClientsRule]
+		if numClientsOk {
+			entry.NumClients, _ = strconv.ParseInt(strings.TrimSpace(numClients), 10, 64)
+		}
+	}
+
+	maxClientsRule, maxClientsRuleOk := info["MaxClientsRule"]
+	if maxClientsRuleOk {
+		maxClients, maxClientsOk := rules[maxClientsRule]
+		if maxClientsOk {
+			entry.MaxClients, _ = strconv.ParseInt(strings.TrimSpace(maxClients), 10, 64)
+		}
+	}
+
+	numBotsRule, numBotsRuleOk := info["NumBotsRule"]
+	if numBotsRuleOk {
+		numBots, numBotsOk := rules[numBotsRule]
+		if numBotsOk {
+			entry.NumBots, _ = strconv.ParseInt(strings.TrimSpace(numBots), 10, 64)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package grokstat
+
+import "testing"
+
+func TestParseBinaryIPv6Entry(t *testing.T) {
+	s1 := []byte("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x69\x87")
+	expectation := "[2001:db8::1]:27015"
+
+	result, resultErr := ParseBinaryIPv6Entry(s1, false)
+
+	if resultErr != nil {
+		t.Errorf(resultErr.Error())
+	}
+
+	if result != expectation {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestSplitRemoteAddr(t *testing.T) {
+	var err error
+	s1 := []string{"[::1]:27015", "[::1]", "::1", "127.0.0.1:27950", "localhost"}
+	expectation := [][2]string{{"::1", "27015"}, {"::1", "27960"}, {"::1", "27960"}, {"127.0.0.1", "27950"}, {"localhost", "27960"}}
+
+	result := [][2]string{}
+	for _, remoteAddr := range s1 {
+		host, port := SplitRemoteAddr(remoteAddr, "27960")
+		result = append(result, [2]string{host, port})
+	}
+
+	for i := range result {
+		if result[i] != expectation[i] {
+			err = CompError
+			break
+		}
+	}
+
+	if err != nil {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
@@ -0,0 +1,273 @@
+package grokstat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MINECRAFT_PACKET_STATUS = 0x00
+	MINECRAFT_PACKET_PING   = 0x01
+)
+
+var minecraftFormattingCodes = regexp.MustCompile("§[0-9a-fk-orA-FK-OR]")
+
+var minecraftColorCodes = map[string]string{"black": "0", "dark_blue": "1", "dark_green": "2", "dark_aqua": "3", "dark_red": "4", "dark_purple": "5", "gold": "6", "gray": "7", "dark_gray": "8", "blue": "9", "green": "a", "aqua": "b", "red": "c", "light_purple": "d", "yellow": "e", "white": "f"}
+
+func MINECRAFTMakeProtocolTemplate() ProtocolEntry {
+	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: MINECRAFTMakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "status"}}, HandlerFunc: MINECRAFTHandler, SplitFunc: MINECRAFTSplitPacket, ResponseIdFunc: MINECRAFTResponseId, ResponseMatchFunc: func(packet Packet, protocolInfo ProtocolEntryInfo) bool {
+		return MINECRAFTResponseId(packet, protocolInfo) != ""
+	}, HttpProtocol: "tcp", ResponseType: "Server info"}, Information: ProtocolEntryInfo{"Name": "Minecraft Server", "ProtocolVersion": "-1", "DefaultRequestPort": "25565"}}
+}
+
+// Appends the value encoded as a VarInt.
+func MINECRAFTAppendVarInt(b []byte, value int32) []byte {
+	v := uint32(value)
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// Reads a VarInt from the beginning of the data. Returns the number of bytes read, zero if the data ends before the VarInt does.
+func MINECRAFTReadVarInt(b []byte) (value int32, n int, err error) {
+	var v uint32
+	for i := 0; i < len(b); i++ {
+		if i >= 5 {
+			return 0, 0, InvalidVarInt
+		}
+		v |= uint32(b[i]&0x7F) << uint(7*i)
+		if b[i]&0x80 == 0 {
+			return int32(v), i + 1, nil
+		}
+	}
+	return 0, 0, nil
+}
+
+func minecraftFrame(body []byte) []byte {
+	return append(MINECRAFTAppendVarInt([]byte{}, int32(len(body))), body...)
+}
+
+func minecraftAppendString(b []byte, s string) []byte {
+	return append(MINECRAFTAppendVarInt(b, int32(len(s))), s...)
+}
+
+// Makes the handshake followed by the status request for the status packet, addressed to the host name as given so that virtual hosts answer, and the ping packet carrying the send time otherwise.
+func MINECRAFTMakePayload(packet Packet, protocolInfo ProtocolEntryInfo) Packet {
+	if packet.Id == "ping" {
+		body := []byte{MINECRAFT_PACKET_PING}
+		body = append(body, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(body[1:], uint64(time.Now().UnixNano()))
+		packet.Data = minecraftFrame(body)
+		return packet
+	}
+
+	host, portString := SplitRemoteAddr(packet.RemoteAddr, protocolInfo["DefaultRequestPort"])
+	if packet.Hostname != "" {
+		host = packet.Hostname
+	}
+	port, _ := strconv.Atoi(portString)
+	protocolVersion, _ := strconv.Atoi(protocolInfo["ProtocolVersion"])
+
+	handshake := MINECRAFTAppendVarInt([]byte{}, 0x00)
+	handshake = MINECRAFTAppendVarInt(handshake, int32(protocolVersion))
+	handshake = minecraftAppendString(handshake, host)
+	handshake = append(handshake, byte(port>>8), byte(port))
+	handshake = MINECRAFTAppendVarInt(handshake, 1)
+
+	packet.Data = append(minecraftFrame(handshake), minecraftFrame([]byte{MINECRAFT_PACKET_STATUS})...)
+	return packet
+}
+
+// Splits the TCP stream into VarInt length-prefixed packets.
+func MINECRAFTSplitPacket(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	length, n, err := MINECRAFTReadVarInt(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if n > 0 && length < 0 {
+		return 0, nil, InvalidResponseLength
+	}
+	if n == 0 || len(data) < n+int(length) {
+		if atEOF && len(data) > 0 {
+			return 0, nil, InvalidResponseLength
+		}
+		return 0, nil, nil
+	}
+	return n + int(length), data[:n+int(length)], nil
+}
+
+// Returns the request the framed response answers: "status" for the JSON status, "ping" for the pong, empty if the packet is neither.
+func MINECRAFTResponseId(packet Packet, protocolInfo ProtocolEntryInfo) string {
+	length, n, err := MINECRAFTReadVarInt(packet.Data)
+	if err != nil || n == 0 || length < 1 || len(packet.Data) != n+int(length) {
+		return ""
+	}
+	body := packet.Data[n:]
+	switch body[0] {
+	case MINECRAFT_PACKET_STATUS:
+		_, sn, sErr := MINECRAFTReadVarInt(body[1:])
+		if sErr == nil && sn > 0 && len(body) > 1+sn && body[1+sn] == '{' {
+			return "status"
+		}
+	case MINECRAFT_PACKET_PING:
+		if len(body) == 9 {
+			return "ping"
+		}
+	}
+	return ""
+}
+
+// Parses the status response and answers it with a ping. The pong completes the entry with the round-trip time.
+func MINECRAFTHandler(packet Packet, protColl *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+	sendPackets = []Packet{}
+
+	protocolId := packet.ProtocolId
+	protocol, protocolExists := protColl.Get(protocolId)
+	if !protocolExists {
+		return sendPackets
+	}
+	remoteIp := packet.RemoteAddr
+
+	switch MINECRAFTResponseId(packet, protocol.Information) {
+	case "status":
+		serverEntry, err := MINECRAFTparsePacket(packet, protocol.Information)
+		if err != nil {
+			messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, err.Error())}
+			serverEntryChan <- MakeErrorServerEntry(remoteIp, protocolId, err)
+			return sendPackets
+		}
+		serverEntry.Protocol = protocolId
+		serverEntry.Host = remoteIp
+		serverEntry.Status = 200
+		serverEntryChan <- serverEntry
+		sendPackets = append(sendPackets, MINECRAFTMakePayload(Packet{Id: "ping", Type: packet.Type, RemoteAddr: remoteIp, ProtocolId: protocolId}, protocol.Information))
+	case "ping":
+		messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - Pong received in %s.", protocolId, remoteIp, packet.RoundTripTime)}
+		serverEntry := MakeServerEntry()
+		serverEntry.Protocol = protocolId
+		serverEntry.Host = remoteIp
+		serverEntry.Status = 200
+		serverEntry.Ping = packet.Ping
+		serverEntryChan <- serverEntry
+	default:
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, InvalidResponseHeader.Error())}
+	}
+	return sendPackets
+}
+
+func MINECRAFTparsePacket(p Packet, protocolInfo ProtocolEntryInfo) (serverEntry ServerEntry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			serverEntry = MakeServerEntry()
+			err = MalformedPacket
+		}
+	}()
+	_, n, _ := MINECRAFTReadVarInt(p.Data)
+	body := p.Data[n+1:]
+	jsonLength, sn, sErr := MINECRAFTReadVarInt(body)
+	if sErr != nil || sn == 0 || len(body) < sn+int(jsonLength) {
+		return MakeServerEntry(), InvalidResponseLength
+	}
+	return MINECRAFTparseStatus(body[sn : sn+int(jsonLength)])
+}
+
+type minecraftChatComponent struct {
+	Text          string                   `json:"text"`
+	Color         string                   `json:"color"`
+	Bold          bool                     `json:"bold"`
+	Italic        bool                     `json:"italic"`
+	Underlined    bool                     `json:"underlined"`
+	Strikethrough bool                     `json:"strikethrough"`
+	Obfuscated    bool                     `json:"obfuscated"`
+	Extra         []minecraftChatComponent `json:"extra"`
+}
+
+func (c *minecraftChatComponent) UnmarshalJSON(b []byte) error {
+	var text string
+	if json.Unmarshal(b, &text) == nil {
+		*c = minecraftChatComponent{Text: text}
+		return nil
+	}
+	type component minecraftChatComponent
+	return json.Unmarshal(b, (*component)(c))
+}
+
+// Returns the text of the component with the formatting as legacy section sign codes.
+func (c minecraftChatComponent) Legacy() string {
+	var buf bytes.Buffer
+	if code, exists := minecraftColorCodes[c.Color]; exists {
+		buf.WriteString("§" + code)
+	}
+	for _, style := range []struct {
+		enabled bool
+		code    string
+	}{{c.Obfuscated, "k"}, {c.Bold, "l"}, {c.Strikethrough, "m"}, {c.Underlined, "n"}, {c.Italic, "o"}} {
+		if style.enabled {
+			buf.WriteString("§" + style.code)
+		}
+	}
+	buf.WriteString(c.Text)
+	for _, extra := range c.Extra {
+		buf.WriteString(extra.Legacy())
+	}
+	return buf.String()
+}
+
+type minecraftStatus struct {
+	Version struct {
+		Name     string `json:"name"`
+		Protocol int    `json:"protocol"`
+	} `json:"version"`
+	Players struct {
+		Max    int64 `json:"max"`
+		Online int64 `json:"online"`
+		Sample []struct {
+			Name string `json:"name"`
+			Id   string `json:"id"`
+		} `json:"sample"`
+	} `json:"players"`
+	Description minecraftChatComponent `json:"description"`
+	ModInfo     *struct {
+		Type string `json:"type"`
+	} `json:"modinfo"`
+	EnforcesSecureChat bool `json:"enforcesSecureChat"`
+}
+
+// Parses the JSON status. The MOTD keeps its formatting as section sign codes in the motd rule, the server name is the MOTD without them.
+func MINECRAFTparseStatus(b []byte) (serverEntry ServerEntry, err error) {
+	var status minecraftStatus
+	if err = json.Unmarshal(b, &status); err != nil {
+		return MakeServerEntry(), MalformedPacket
+	}
+
+	serverEntry = MakeServerEntry()
+	motd := status.Description.Legacy()
+	serverEntry.Name = strings.TrimSpace(minecraftFormattingCodes.ReplaceAllString(motd, ""))
+	serverEntry.NumClients = status.Players.Online
+	serverEntry.MaxClients = status.Players.Max
+	serverEntry.Secure = status.EnforcesSecureChat
+	if status.ModInfo != nil {
+		serverEntry.ModName = status.ModInfo.Type
+	}
+
+	for _, sample := range status.Players.Sample {
+		player := MakePlayerEntry()
+		player.Name = sample.Name
+		player.Info["id"] = sample.Id
+		serverEntry.Players = append(serverEntry.Players, player)
+	}
+
+	serverEntry.Rules["motd"] = motd
+	serverEntry.Rules["version"] = status.Version.Name
+	serverEntry.Rules["protocol"] = strconv.Itoa(status.Version.Protocol)
+
+	return serverEntry, nil
+}
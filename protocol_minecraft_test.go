@@ -0,0 +1,141 @@
+package grokstat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMINECRAFTVarInt(t *testing.T) {
+	for _, expectation := range []int32{0, 1, 127, 128, 255, 25565, 2097151, 2147483647, -1} {
+		encoded := MINECRAFTAppendVarInt([]byte{}, expectation)
+		result, n, err := MINECRAFTReadVarInt(encoded)
+		if err != nil || n != len(encoded) || result != expectation {
+			t.Errorf(ErrorOut(expectation, result))
+		}
+	}
+
+	if _, _, err := MINECRAFTReadVarInt([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}); err != InvalidVarInt {
+		t.Errorf(ErrorOut(InvalidVarInt, err))
+	}
+	if _, n, _ := MINECRAFTReadVarInt([]byte{0xFF}); n != 0 {
+		t.Errorf(ErrorOut(0, n))
+	}
+}
+
+func TestMINECRAFTparseStatus(t *testing.T) {
+	status := []byte(`{"version":{"name":"1.20.1","protocol":763},"players":{"max":20,"online":2,"sample":[{"name":"Notch","id":"069a79f4-44e9-4726-a5be-fca90e38aaf5"},{"name":"jeb_","id":"853c80ef-3c37-49fd-aa49-938b674adae6"}]},"description":{"text":"A ","extra":[{"text":"Grok","color":"gold","bold":true},"stat server"]},"enforcesSecureChat":true}`)
+	expectation := ServerEntry{Name: "A Grokstat server", NumClients: 2, MaxClients: 20, Secure: true, Players: []PlayerEntry{{Name: "Notch", Info: map[string]string{"id": "069a79f4-44e9-4726-a5be-fca90e38aaf5"}}, {Name: "jeb_", Info: map[string]string{"id": "853c80ef-3c37-49fd-aa49-938b674adae6"}}}, Rules: map[string]string{"motd": "A §6§lGrokstat server", "version": "1.20.1", "protocol": "763"}}
+
+	result, err := MINECRAFTparseStatus(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expectation, result) {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+
+	result, err = MINECRAFTparseStatus([]byte(`{"description":"§aLegacy MOTD","players":{"max":10,"online":0}}`))
+	if err != nil || result.Name != "Legacy MOTD" || result.Rules["motd"] != "§aLegacy MOTD" {
+		t.Errorf(ErrorOut("Legacy MOTD", result))
+	}
+}
+
+func TestMINECRAFTSplitPacket(t *testing.T) {
+	var err error
+	first := minecraftFrame([]byte{MINECRAFT_PACKET_PING, 1, 2, 3, 4, 5, 6, 7, 8})
+	second := minecraftFrame(append([]byte{MINECRAFT_PACKET_STATUS}, minecraftAppendString([]byte{}, "{}")...))
+	expectation := [][]byte{first, second}
+
+	scanner := bufio.NewScanner(bytes.NewReader(append(append([]byte{}, first...), second...)))
+	scanner.Split(MINECRAFTSplitPacket)
+	result := [][]byte{}
+	for scanner.Scan() {
+		result = append(result, append([]byte{}, scanner.Bytes()...))
+	}
+	if !reflect.DeepEqual(expectation, result) || scanner.Err() != nil {
+		err = CompError
+	}
+
+	if err != nil {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func startMinecraftStub(t *testing.T, status string) net.Listener {
+	listener, lErr := net.Listen("tcp4", "127.0.0.1:0")
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+
+	go func() {
+		for {
+			conn, aErr := listener.Accept()
+			if aErr != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				scanner.Split(MINECRAFTSplitPacket)
+				for i := 0; scanner.Scan(); i++ {
+					_, n, _ := MINECRAFTReadVarInt(scanner.Bytes())
+					body := scanner.Bytes()[n:]
+					switch {
+					case i == 1 && body[0] == MINECRAFT_PACKET_STATUS:
+						conn.Write(minecraftFrame(append([]byte{MINECRAFT_PACKET_STATUS}, minecraftAppendString([]byte{}, status)...)))
+					case body[0] == MINECRAFT_PACKET_PING:
+						conn.Write(scanner.Bytes())
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func TestMINECRAFTMakePayloadHostname(t *testing.T) {
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "minecraft", Template: "MINECRAFT"}})
+	packErrPairs := MakePacketErrorPair([]HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: "localhost:25566", ProtocolId: "minecraft"}}, protColl)
+	if len(packErrPairs) != 1 || packErrPairs[0].Error != nil {
+		t.Fatalf(ErrorOut("status request", packErrPairs))
+	}
+
+	packet := packErrPairs[0].Packet
+	expectation := append(minecraftAppendString([]byte{}, "localhost"), 0x63, 0xDE)
+	if _, port := SplitRemoteAddr(packet.RemoteAddr, ""); port != "25566" || !bytes.Contains(packet.Data, expectation) {
+		t.Errorf(ErrorOut(expectation, packet.Data))
+	}
+}
+
+func TestQueryMinecraft(t *testing.T) {
+	listener := startMinecraftStub(t, `{"version":{"name":"1.20.1","protocol":763},"players":{"max":20,"online":1},"description":"Stub"}`)
+	defer listener.Close()
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "minecraft", Template: "MINECRAFT"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: listener.Addr().String(), ProtocolId: "minecraft"}}
+	counters := MakeQueryCounters()
+
+	result, err := Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: 300 * time.Millisecond, Counters: counters})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectation := fmt.Sprint(200, " Stub ", 1, 20, " 1.20.1")
+	if len(result.Servers) != 1 {
+		t.Fatalf(ErrorOut(expectation, result.Servers))
+	}
+	entry := result.Servers[0]
+	if fmt.Sprint(entry.Status, " ", entry.Name, " ", entry.NumClients, entry.MaxClients, " ", entry.Rules["version"]) != expectation {
+		t.Errorf(ErrorOut(expectation, entry))
+	}
+	if received := counters.Snapshot().PacketsReceived["minecraft"]; received != 2 {
+		t.Errorf(ErrorOut("status and pong", received))
+	}
+}
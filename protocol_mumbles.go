@@ -1,4 +1,4 @@
-package main
+package grokstat
 
 import (
 	"bytes"
@@ -9,7 +9,12 @@ import (
 func MUMBLESMakeProtocolTemplate() ProtocolEntry {
 	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: MakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "ping"}}, HandlerFunc: func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) []Packet {
 		return SimpleReceiveHandler(MUMBLESparsePacket, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
-	}, HttpProtocol: "udp", ResponseType: "Server ping"}, Information: ProtocolEntryInfo{"Name": "Mumble Server", "PreludeStarter": "\x00\x00\x00\x00", "PreludeFinisher": "", "Challenge": "grokstat", "RequestPreludeTemplate": "{{.PreludeStarter}}{{.Challenge}}{{.PreludeFinisher}}", "DefaultRequestPort": "64738"}}
+	}, ResponseMatchFunc: MUMBLESMatchResponse, HttpProtocol: "udp", ResponseType: "Server ping"}, Information: ProtocolEntryInfo{"Name": "Mumble Server", "PreludeStarter": "\x00\x00\x00\x00", "PreludeFinisher": "", "Challenge": "grokstat", "RequestPreludeTemplate": "{{.PreludeStarter}}{{.Challenge}}{{.PreludeFinisher}}", "DefaultRequestPort": "64738"}}
+}
+
+// Matches the responses of the expected length which echo the challenge.
+func MUMBLESMatchResponse(p Packet, info ProtocolEntryInfo) bool {
+	return len(p.Data) == 24 && string(p.Data[4:12]) == info["Challenge"]
 }
 
 func MUMBLESparsePacket(p Packet, info ProtocolEntryInfo) (v ServerEntry, err error) {
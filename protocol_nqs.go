@@ -0,0 +1,307 @@
+package grokstat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	NQS_NETFLAG_CTL          = 0x8000
+	NQS_CCREQ_SERVER_INFO    = 0x02
+	NQS_CCREQ_PLAYER_INFO    = 0x03
+	NQS_CCREQ_RULE_INFO      = 0x04
+	NQS_CCREP_SERVER_INFO    = 0x83
+	NQS_CCREP_PLAYER_INFO    = 0x84
+	NQS_CCREP_RULE_INFO      = 0x85
+	NQS_NET_PROTOCOL_VERSION = 3
+	NQS_QUERY_EXPIRY         = 30 * time.Second
+)
+
+func NQSMakeProtocolTemplate() ProtocolEntry {
+	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: NQSMakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "serverinfo"}, RequestPacket{Id: "rule"}}, PrepareQueryFunc: NQSPrepareQuery, ResponseIdFunc: NQSResponseId, ResponseMatchFunc: func(packet Packet, protocolInfo ProtocolEntryInfo) bool {
+		return NQSResponseId(packet, protocolInfo) != ""
+	}, HttpProtocol: "udp", ResponseType: "Server info"}, Information: ProtocolEntryInfo{"Name": "NetQuake", "GameName": "QUAKE", "DefaultRequestPort": "26000"}}
+}
+
+// Sets up the handler for one query. The players and rules are collected for the hosts queried by it.
+func NQSPrepareQuery(base *ProtocolEntryBase) {
+	queries := MakeNQSQueryCollection()
+	base.HandlerFunc = func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+		return NQSHandler(queries, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	}
+}
+
+// Frames the control request: the flagged length of the whole packet, the command and its data.
+func makeNQSRequest(command byte, data []byte) []byte {
+	request := make([]byte, 3, 3+len(data))
+	binary.BigEndian.PutUint16(request, uint16(NQS_NETFLAG_CTL|(3+len(data))))
+	request[2] = command
+	return append(request, data...)
+}
+
+// Makes the server info request, the first rule request and the player request for the player number set as the packet data.
+func NQSMakePayload(packet Packet, protocolInfo ProtocolEntryInfo) Packet {
+	switch packet.Id {
+	case "serverinfo":
+		packet.Data = makeNQSRequest(NQS_CCREQ_SERVER_INFO, append([]byte(protocolInfo["GameName"]+"\x00"), NQS_NET_PROTOCOL_VERSION))
+	case "player":
+		packet.Data = makeNQSRequest(NQS_CCREQ_PLAYER_INFO, packet.Data)
+	case "rule":
+		packet.Data = makeNQSRequest(NQS_CCREQ_RULE_INFO, append(packet.Data, 0))
+	}
+	return packet
+}
+
+// Returns the control response body following the command, failing if the length does not match the packet.
+func nqsResponseBody(data []byte) (command byte, body []byte, ok bool) {
+	if len(data) < 3 {
+		return 0, nil, false
+	}
+	header := binary.BigEndian.Uint16(data)
+	if header&NQS_NETFLAG_CTL == 0 || int(header&^NQS_NETFLAG_CTL) != len(data) {
+		return 0, nil, false
+	}
+	return data[2], data[3:], true
+}
+
+// Returns the id of the request the control response answers.
+func NQSResponseId(packet Packet, protocolInfo ProtocolEntryInfo) string {
+	command, _, ok := nqsResponseBody(packet.Data)
+	if !ok {
+		return ""
+	}
+	switch command {
+	case NQS_CCREP_SERVER_INFO:
+		return "serverinfo"
+	case NQS_CCREP_PLAYER_INFO:
+		return "player"
+	case NQS_CCREP_RULE_INFO:
+		return "rule"
+	default:
+		return ""
+	}
+}
+
+type nqsQuery struct {
+	players map[int]PlayerEntry
+	rules   map[string]string
+	updated time.Time
+}
+
+// Collects the player and rule responses keyed by remote address until the rule list is complete.
+type NQSQueryCollection struct {
+	sync.Mutex
+	data map[string]*nqsQuery
+}
+
+func MakeNQSQueryCollection() *NQSQueryCollection {
+	return &NQSQueryCollection{data: map[string]*nqsQuery{}}
+}
+
+func (c *NQSQueryCollection) get(remoteAddr string) *nqsQuery {
+	now := time.Now()
+	for k, v := range c.data {
+		if now.Sub(v.updated) > NQS_QUERY_EXPIRY {
+			delete(c.data, k)
+		}
+	}
+	query, exists := c.data[remoteAddr]
+	if !exists {
+		query = &nqsQuery{players: map[int]PlayerEntry{}, rules: map[string]string{}}
+		c.data[remoteAddr] = query
+	}
+	query.updated = now
+	return query
+}
+
+// Stores the player. Returns the players received so far ordered by the player number.
+func (c *NQSQueryCollection) AddPlayer(remoteAddr string, number int, player PlayerEntry) []PlayerEntry {
+	c.Lock()
+	defer c.Unlock()
+	query := c.get(remoteAddr)
+	query.players[number] = player
+	numbers := []int{}
+	for number := range query.players {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	players := []PlayerEntry{}
+	for _, number := range numbers {
+		players = append(players, query.players[number])
+	}
+	return players
+}
+
+// Stores the rule. Returns false if the rule has already been received, which ends the iteration.
+func (c *NQSQueryCollection) AddRule(remoteAddr string, name string, value string) bool {
+	c.Lock()
+	defer c.Unlock()
+	query := c.get(remoteAddr)
+	if _, exists := query.rules[name]; exists {
+		return false
+	}
+	query.rules[name] = value
+	return true
+}
+
+// Returns the rules received so far and starts over.
+func (c *NQSQueryCollection) TakeRules(remoteAddr string) map[string]string {
+	c.Lock()
+	defer c.Unlock()
+	query := c.get(remoteAddr)
+	rules := query.rules
+	query.rules = map[string]string{}
+	return rules
+}
+
+// Answers the server info with the player requests and each rule with the request for the next one. The players received so far are sent with each player response, so the players answering are listed even if others do not. The rules are sent once complete.
+func NQSHandler(queries *NQSQueryCollection, packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+	sendPackets = []Packet{}
+
+	protocolId := packet.ProtocolId
+	protocol, protocolExists := protocolCollection.Get(protocolId)
+	if !protocolExists {
+		return sendPackets
+	}
+	protocolInfo := protocol.Information
+	remoteIp := packet.RemoteAddr
+
+	sendEntry := func(serverEntry ServerEntry) {
+		serverEntry.Protocol = protocolId
+		serverEntry.Host = remoteIp
+		serverEntry.Status = 200
+		serverEntryChan <- serverEntry
+	}
+	sendPlayers := func(players []PlayerEntry) {
+		serverEntry := MakeServerEntry()
+		serverEntry.Players = players
+		sendEntry(serverEntry)
+	}
+	sendError := func(err error) {
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, err.Error())}
+		serverEntryChan <- MakeErrorServerEntry(remoteIp, protocolId, err)
+	}
+
+	command, body, ok := nqsResponseBody(packet.Data)
+	if !ok {
+		sendError(InvalidResponseHeader)
+		return sendPackets
+	}
+
+	switch command {
+	case NQS_CCREP_SERVER_INFO:
+		serverEntry, err := NQSparseServerInfo(body)
+		if err != nil {
+			sendError(err)
+			return sendPackets
+		}
+		serverEntry.Ping = packet.Ping
+		sendEntry(serverEntry)
+		for i := 0; i < int(serverEntry.NumClients); i++ {
+			sendPackets = append(sendPackets, NQSMakePayload(Packet{Id: "player", Type: packet.Type, RemoteAddr: remoteIp, ProtocolId: protocolId, Data: []byte{byte(i)}}, protocolInfo))
+		}
+	case NQS_CCREP_PLAYER_INFO:
+		number, player, err := NQSparsePlayerInfo(body)
+		if err != nil {
+			messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, err.Error())}
+			return sendPackets
+		}
+		sendPlayers(queries.AddPlayer(remoteIp, number, player))
+	case NQS_CCREP_RULE_INFO:
+		name, value, err := NQSparseRuleInfo(body)
+		if err != nil {
+			messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - %s", protocolId, remoteIp, err.Error())}
+			return sendPackets
+		}
+		if name != "" && queries.AddRule(remoteIp, name, value) {
+			sendPackets = append(sendPackets, NQSMakePayload(Packet{Id: "rule", Type: packet.Type, RemoteAddr: remoteIp, ProtocolId: protocolId, Data: []byte(name)}, protocolInfo))
+			return sendPackets
+		}
+		messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - Rule list complete.", protocolId, remoteIp)}
+		rules := queries.TakeRules(remoteIp)
+		serverEntry := MakeServerEntry()
+		serverEntry.Rules = rules
+		ApplyRuleMappings(&serverEntry, rules, protocolInfo)
+		sendEntry(serverEntry)
+	default:
+		sendError(InvalidServerHeader)
+	}
+	return sendPackets
+}
+
+func readNQSString(buf *bytes.Buffer) (string, error) {
+	b, err := buf.ReadBytes(0)
+	if err != nil {
+		return "", InvalidResponseLength
+	}
+	return string(b[:len(b)-1]), nil
+}
+
+// Parses the server info: address, host name, level name, player count, player limit and protocol version.
+func NQSparseServerInfo(b []byte) (entry ServerEntry, err error) {
+	buf := bytes.NewBuffer(b)
+	entry = MakeServerEntry()
+	var address, levelName string
+	if address, err = readNQSString(buf); err != nil {
+		return MakeServerEntry(), err
+	}
+	if entry.Name, err = readNQSString(buf); err != nil {
+		return MakeServerEntry(), err
+	}
+	if levelName, err = readNQSString(buf); err != nil {
+		return MakeServerEntry(), err
+	}
+	counts := buf.Next(3)
+	if len(counts) != 3 {
+		return MakeServerEntry(), InvalidResponseLength
+	}
+	entry.Terrain = levelName
+	entry.NumClients = int64(counts[0])
+	entry.MaxClients = int64(counts[1])
+	entry.Rules["address"] = address
+	entry.Rules["protocol"] = strconv.Itoa(int(counts[2]))
+	return entry, nil
+}
+
+// Parses the player info: player number, name, colours, frags, connection time in seconds and address.
+func NQSparsePlayerInfo(b []byte) (number int, player PlayerEntry, err error) {
+	buf := bytes.NewBuffer(b)
+	player = MakePlayerEntry()
+	numberByte, nErr := buf.ReadByte()
+	if nErr != nil {
+		return 0, player, InvalidPlayerStringLength
+	}
+	if player.Name, err = readNQSString(buf); err != nil {
+		return 0, player, InvalidPlayerStringLength
+	}
+	values := buf.Next(12)
+	if len(values) != 12 {
+		return 0, player, InvalidPlayerStringLength
+	}
+	colors := binary.LittleEndian.Uint32(values[0:4])
+	player.Info["topcolor"] = strconv.Itoa(int(colors>>4) & 0x0F)
+	player.Info["bottomcolor"] = strconv.Itoa(int(colors) & 0x0F)
+	player.Info["frags"] = strconv.Itoa(int(int32(binary.LittleEndian.Uint32(values[4:8]))))
+	player.Info["time"] = strconv.Itoa(int(int32(binary.LittleEndian.Uint32(values[8:12]))))
+	return int(numberByte), player, nil
+}
+
+// Parses the rule info. The response without a rule name ends the rule list.
+func NQSparseRuleInfo(b []byte) (name string, value string, err error) {
+	if len(b) == 0 {
+		return "", "", nil
+	}
+	buf := bytes.NewBuffer(b)
+	if name, err = readNQSString(buf); err != nil {
+		return "", "", InvalidRuleStringLength
+	}
+	if value, err = readNQSString(buf); err != nil {
+		return "", "", InvalidRuleStringLength
+	}
+	return name, value, nil
+}
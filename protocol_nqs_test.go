@@ -0,0 +1,116 @@
+package grokstat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// Starts a NetQuake server stub. The player slots with an empty name do not answer.
+func startNQSStub(t *testing.T, rules [][2]string, players []string) *net.UDPConn {
+	conn, lErr := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+
+	reply := func(addr *net.UDPAddr, command byte, data string) {
+		conn.WriteToUDP(makeNQSRequest(command, []byte(data)), addr)
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			command, body, ok := nqsResponseBody(buf[:n])
+			if !ok {
+				continue
+			}
+			switch command {
+			case NQS_CCREQ_SERVER_INFO:
+				reply(addr, NQS_CCREP_SERVER_INFO, fmt.Sprintf("127.0.0.1:26000\x00Grok NQ\x00e1m1\x00%c\x08\x03", len(players)))
+			case NQS_CCREQ_PLAYER_INFO:
+				if players[body[0]] == "" {
+					continue
+				}
+				reply(addr, NQS_CCREP_PLAYER_INFO, fmt.Sprintf("%c%s\x00\x4B\x00\x00\x00\x07\x00\x00\x00\x3C\x00\x00\x00127.0.0.1:1\x00", body[0], players[body[0]]))
+			case NQS_CCREQ_RULE_INFO:
+				previous := string(bytes.TrimRight(body, "\x00"))
+				next := 0
+				for i, rule := range rules {
+					if rule[0] == previous {
+						next = i + 1
+					}
+				}
+				if next < len(rules) {
+					reply(addr, NQS_CCREP_RULE_INFO, rules[next][0]+"\x00"+rules[next][1]+"\x00")
+				} else {
+					reply(addr, NQS_CCREP_RULE_INFO, "")
+				}
+			}
+		}
+	}()
+	return conn
+}
+
+func TestQueryNQS(t *testing.T) {
+	conn := startNQSStub(t, [][2]string{{"sv_maxspeed", "320"}, {"deathmatch", "1"}, {"fraglimit", "30"}}, []string{"Grok", "Stat"})
+	defer conn.Close()
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "nqs", Template: "NQS"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: conn.LocalAddr().String(), ProtocolId: "nqs"}}
+	expectation := fmt.Sprint(200, " Grok NQ e1m1 ", 2, 8, " map[deathmatch:1 fraglimit:30 sv_maxspeed:320] [Grok Stat] map[bottomcolor:11 frags:7 time:60 topcolor:4]")
+
+	result, err := Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Servers) != 1 {
+		t.Fatalf(ErrorOut(expectation, result.Servers))
+	}
+	entry := result.Servers[0]
+	names := []string{}
+	for _, player := range entry.Players {
+		names = append(names, player.Name)
+	}
+	rules := map[string]string{}
+	for _, key := range []string{"sv_maxspeed", "deathmatch", "fraglimit"} {
+		rules[key] = entry.Rules[key]
+	}
+	var playerInfo map[string]string
+	if len(entry.Players) > 0 {
+		playerInfo = entry.Players[0].Info
+	}
+	if fmt.Sprint(entry.Status, " ", entry.Name, " ", entry.Terrain, " ", entry.NumClients, entry.MaxClients, " ", rules, " ", names, " ", playerInfo) != expectation {
+		t.Errorf(ErrorOut(expectation, entry))
+	}
+}
+
+func TestQueryNQSMissingPlayer(t *testing.T) {
+	conn := startNQSStub(t, [][2]string{{"deathmatch", "1"}}, []string{"Grok", "", "Stat"})
+	defer conn.Close()
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "nqs", Template: "NQS", Overrides: map[string]string{"Retries": "1", "RetryBackoff": "50"}}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: conn.LocalAddr().String(), ProtocolId: "nqs"}}
+	expectation := fmt.Sprint(3, " ", []string{"Grok", "Stat"})
+
+	result, err := Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Servers) != 1 {
+		t.Fatalf(ErrorOut(expectation, result.Servers))
+	}
+	entry := result.Servers[0]
+	names := []string{}
+	for _, player := range entry.Players {
+		names = append(names, player.Name)
+	}
+	if fmt.Sprint(entry.NumClients, " ", names) != expectation {
+		t.Errorf(ErrorOut(expectation, entry))
+	}
+}
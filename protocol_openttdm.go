@@ -1,4 +1,4 @@
-package main
+package grokstat
 
 import "bytes"
 
@@ -8,12 +8,34 @@ const (
 	SLT_IPV6 = iota
 )
 
+const (
+	OPENTTD_PACKET_SERVER_RESPONSE = 0x01
+	OPENTTD_PACKET_MASTER_RESPONSE = 0x07
+)
+
 func OPENTTDMMakeProtocolTemplate() ProtocolEntry {
-	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: MakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "servers4"}}, HandlerFunc: func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: OPENTTDMMakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "servers4"}, RequestPacket{Id: "servers6"}}, HandlerFunc: func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
 		return MasterReceiveHandler(OPENTTDMparsePacket, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	}, ResponseMatchFunc: func(packet Packet, protocolInfo ProtocolEntryInfo) bool {
+		return OPENTTDMatchPacket(packet.Data, OPENTTD_PACKET_MASTER_RESPONSE)
 	}, HttpProtocol: "udp", ResponseType: "Server list"}, Information: ProtocolEntryInfo{"Name": "OpenTTD Master", "DefaultRequestPort": "3978", "ProtocolVer": string(byte(2)), "IPType": string(byte(0)), "RequestPreludeTemplate": "\x05\x00\x06{{.ProtocolVer}}{{.IPType}}"}}
 }
 
+// Requests the IPv4 or IPv6 server list depending on the packet id.
+func OPENTTDMMakePayload(packet Packet, protocolInfo ProtocolEntryInfo) Packet {
+	info := make(ProtocolEntryInfo, len(protocolInfo))
+	for k, v := range protocolInfo {
+		info[k] = v
+	}
+	switch packet.Id {
+	case "servers4":
+		info["IPType"] = string(byte(SLT_IPV4 - 1))
+	case "servers6":
+		info["IPType"] = string(byte(SLT_IPV6 - 1))
+	}
+	return MakePayload(packet, info)
+}
+
 func OPENTTDMparsePacket(p Packet, i ProtocolEntryInfo) ([]string, error) {
 	return OPENTTDMparseData(p.Data)
 }
@@ -26,22 +48,31 @@ func OPENTTDMparseData(data []byte) ([]string, error) {
 	for buf.Len() > 0 {
 		_ = buf.Next(2)
 		var responseNum = int(buf.Next(1)[0])
-		if responseNum != 7 {
+		if responseNum != OPENTTD_PACKET_MASTER_RESPONSE {
 			return nil, MalformedPacket
 		}
 		var ipVer = int(buf.Next(1)[0])
-		if ipVer == SLT_IPV6 {
-			return nil, IPv6NotSupported
+		var entryLen int
+		var parseEntry func([]byte, bool) (string, error)
+		switch ipVer {
+		case SLT_IPV4:
+			entryLen = 6
+			parseEntry = ParseBinaryIPv4Entry
+		case SLT_IPV6:
+			entryLen = 18
+			parseEntry = ParseBinaryIPv6Entry
+		default:
+			return nil, MalformedPacket
 		}
 		var hostnumLE = buf.Next(2)
 		var hostnum = int(hostnumLE[1])<<8 | int(hostnumLE[0])
 
-		if buf.Len() < hostnum*6 {
+		if buf.Len() < hostnum*entryLen {
 			return nil, MalformedPacket
 		}
 
 		for i := 0; i < hostnum; i++ {
-			entry, entryErr := ParseBinaryIPv4Entry(buf.Next(6), true)
+			entry, entryErr := parseEntry(buf.Next(entryLen), true)
 			if entryErr == nil {
 				servers = append(servers, entry)
 			}
@@ -1,4 +1,4 @@
-package main
+package grokstat
 
 import "testing"
 
@@ -28,3 +28,30 @@ func TestOPENTTDMparseData(t *testing.T) {
 		t.Errorf(ErrorOut(expectation, result))
 	}
 }
+
+func TestOPENTTDMparseDataIPv6(t *testing.T) {
+	var err error
+	var s1 = Packet{Id: "servers6", Data: []byte("\x1A\x00\x07\x02\x01\x00\x20\x01\x0D\xB8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x8B\x0F")}
+	expectation := []string{"[2001:db8::1]:3979"}
+
+	result, resultErr := OPENTTDMparseData(s1.Data)
+
+	if resultErr != nil {
+		t.Errorf(resultErr.Error())
+	}
+
+	if len(result) != len(expectation) {
+		err = CompError
+	} else {
+		for i := range result {
+			if result[i] != expectation[i] {
+				err = CompError
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
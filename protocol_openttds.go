@@ -1,4 +1,4 @@
-package main
+package grokstat
 
 import (
 	"bytes"
@@ -12,9 +12,20 @@ import (
 func OPENTTDSMakeProtocolTemplate() ProtocolEntry {
 	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: MakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "info"}}, HandlerFunc: func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
 		return SimpleReceiveHandler(OPENTTDSparsePacket, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	}, ResponseMatchFunc: func(packet Packet, protocolInfo ProtocolEntryInfo) bool {
+		return OPENTTDMatchPacket(packet.Data, OPENTTD_PACKET_SERVER_RESPONSE)
 	}, HttpProtocol: "udp", ResponseType: "Server info"}, Information: ProtocolEntryInfo{"Name": "OpenTTD Server", "PreludeStarter": "", "PreludeFinisher": "\x00\x00", "RequestPreludeTemplate": "{{.PreludeStarter}}\x03{{.PreludeFinisher}}", "DefaultRequestPort": "3979"}}
 }
 
+// Matches the packets of the specified type whose size field agrees with the data length.
+func OPENTTDMatchPacket(data []byte, packetType byte) bool {
+	if len(data) < 3 || data[2] != packetType {
+		return false
+	}
+	size := int(data[1])<<8 | int(data[0])
+	return size >= 3 && size <= len(data)
+}
+
 func OPENTTDSparsePacket(p Packet, protocolInfo ProtocolEntryInfo) (serverEntry ServerEntry, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -1,4 +1,4 @@
-package main
+package grokstat
 
 import (
 	"bytes"
@@ -8,7 +8,7 @@ import (
 func Q3MMakeProtocolTemplate() ProtocolEntry {
 	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: MakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "servers"}}, HandlerFunc: func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
 		return MasterReceiveHandler(Q3MParsePacket, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
-	}, HttpProtocol: "udp", ResponseType: "Server list"}, Information: ProtocolEntryInfo{"Name": "Quake III Arena Master", "SplitterUsed": "true", "PreludeStarter": "\xFF\xFF\xFF\xFF", "RequestQueryParams": "empty full", "RequestPreludeTemplate": "{{.PreludeStarter}}getservers {{.Version}} {{.RequestQueryParams}}\n", "ResponsePreludeTemplate": "{{.PreludeStarter}}getserversResponse", "Version": "68", "DefaultRequestPort": "27950"}}
+	}, ResponseMatchFunc: MatchResponsePrelude, HttpProtocol: "udp", ResponseType: "Server list"}, Information: ProtocolEntryInfo{"Name": "Quake III Arena Master", "SplitterUsed": "true", "PreludeStarter": "\xFF\xFF\xFF\xFF", "RequestQueryParams": "empty full", "RequestPreludeTemplate": "{{.PreludeStarter}}getservers {{.Version}} {{.RequestQueryParams}}\n", "ResponsePreludeTemplate": "{{.PreludeStarter}}getserversResponse", "Version": "68", "DefaultRequestPort": "27950"}}
 }
 
 // Parses the response from Quake III Arena master server.
@@ -27,23 +27,40 @@ func Q3MParsePacket(p Packet, protocolInfo ProtocolEntryInfo) ([]string, error)
 
 	var servers = []string{}
 
-	var splitter = []byte{0x5c}
-	var payloadSplit [][]byte
 	if splitterUsed == "true" {
-		payloadSplit = bytes.Split(payload, splitter)
+		// Each entry is prefixed with a separator: backslash for IPv4 and slash for DarkPlaces IPv6 entries.
+		var eot = []byte("EOT\x00\x00\x00")
+		var buf = bytes.NewBuffer(payload)
+		for buf.Len() > 0 {
+			var entryLen int
+			var parseEntry func([]byte, bool) (string, error)
+			switch buf.Next(1)[0] {
+			case 0x5c:
+				entryLen = 6
+				parseEntry = ParseBinaryIPv4Entry
+			case 0x2f:
+				entryLen = 18
+				parseEntry = ParseBinaryIPv6Entry
+			default:
+				return servers, nil
+			}
+			if bytes.HasPrefix(buf.Bytes(), eot) || buf.Len() < entryLen {
+				break
+			}
+			var serverEntry, entryErr = parseEntry(buf.Next(entryLen), false)
+			if entryErr == nil {
+				servers = append(servers, serverEntry)
+			}
+		}
 	} else {
 		if math.Mod(float64(len(payload)), 6.0) != 0.0 {
 			return nil, InvalidResponseLength
 		}
 		for i := 0; i < int(len(payload)/6.0); i++ {
-			payloadSplit = append(payloadSplit, payload[i:i+6])
-		}
-	}
-	for _, entryRaw := range payloadSplit {
-		var serverEntry, entryErr = ParseBinaryIPv4Entry(entryRaw, false)
-
-		if entryErr == nil {
-			servers = append(servers, serverEntry)
+			var serverEntry, entryErr = ParseBinaryIPv4Entry(payload[i*6:i*6+6], false)
+			if entryErr == nil {
+				servers = append(servers, serverEntry)
+			}
 		}
 	}
 	return servers, nil
@@ -0,0 +1,32 @@
+package grokstat
+
+import "testing"
+
+func TestQ3MParsePacket(t *testing.T) {
+	var err error
+	protocolInfo := Q3MMakeProtocolTemplate().Information
+	protocolInfo["ResponsePreludeTemplate"] = "{{.PreludeStarter}}getserversExtResponse"
+	s1 := Packet{Id: "servers", Data: []byte("\xFF\xFF\xFF\xFFgetserversExtResponse\x5C\x4A\xD0\x4B\xB7\x6D\x38\x2F\x20\x01\x0D\xB8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x65\x90\x5CEOT\x00\x00\x00")}
+	expectation := []string{"74.208.75.183:27960", "[2001:db8::1]:26000"}
+
+	result, resultErr := Q3MParsePacket(s1, protocolInfo)
+
+	if resultErr != nil {
+		t.Errorf(resultErr.Error())
+	}
+
+	if len(result) != len(expectation) {
+		err = CompError
+	} else {
+		for i := range result {
+			if result[i] != expectation[i] {
+				err = CompError
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package grokstat
 
 import (
 	"bytes"
@@ -11,7 +11,7 @@ import (
 func Q3SMakeProtocolTemplate() ProtocolEntry {
 	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: MakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "status", ResponsePacketNum: 1}}, HandlerFunc: func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
 		return SimpleReceiveHandler(Q3SParsePacket, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
-	}, HttpProtocol: "udp", ResponseType: "Server info"}, Information: ProtocolEntryInfo{"Name": "Quake III Arena", "PreludeStarter": "\xFF\xFF\xFF\xFF", "Challenge": "GrokStat_" + string(time.Now().Unix()), "RequestPreludeTemplate": "{{.PreludeStarter}}getstatus {{.Challenge}}\n", "headerTemplate": "{{.PreludeStarter}}statusResponse", "ServerNameRule": "sv_hostname", "NeedPassRule": "g_needpass", "TerrainRule": "mapname", "ModNameRule": "game", "GameTypeRule": "g_gametype", "MaxClientsRule": "sv_maxclients", "SecureRule": "sv_punkbuster", "Version": "68", "DefaultRequestPort": "27950"}}
+	}, ResponseMatchFunc: Q3SMatchResponse, HttpProtocol: "udp", ResponseType: "Server info"}, Information: ProtocolEntryInfo{"Name": "Quake III Arena", "PreludeStarter": "\xFF\xFF\xFF\xFF", "Challenge": "GrokStat_" + strconv.FormatInt(time.Now().Unix(), 10), "RequestPreludeTemplate": "{{.PreludeStarter}}getstatus {{.Challenge}}\n", "headerTemplate": "{{.PreludeStarter}}statusResponse", "ServerNameRule": "sv_hostname", "NeedPassRule": "g_needpass", "TerrainRule": "mapname", "ModNameRule": "game", "GameTypeRule": "g_gametype", "MaxClientsRule": "sv_maxclients", "SecureRule": "sv_punkbuster", "Version": "68", "DefaultRequestPort": "27950"}}
 }
 
 func Q3SParsePlayerstring(arr [][]byte) []PlayerEntry {
@@ -57,8 +57,23 @@ func Q3SParseRulestring(str [][]byte) map[string]string {
 	return m
 }
 
+// Matches the responses starting with the header template.
+func Q3SMatchResponse(p Packet, info ProtocolEntryInfo) bool {
+	headerTemplate := info["headerTemplate"]
+	if headerTemplate == "" {
+		return false
+	}
+	_, headerOk := CheckPrelude(p.Data, []byte(ParseTemplate(headerTemplate, info)))
+	return headerOk
+}
+
 // Parses the response from Quake III Arena server
 func Q3SParsePacket(p Packet, info ProtocolEntryInfo) (entry ServerEntry, err error) {
+	return parseQuakeStatus(p, info, Q3SParsePlayerstring)
+}
+
+// Parses the rule line and the player lines following the header, the player lines are parsed with the game specific function.
+func parseQuakeStatus(p Packet, info ProtocolEntryInfo, parsePlayers func([][]byte) []PlayerEntry) (entry ServerEntry, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = MalformedPacket
@@ -92,7 +107,7 @@ func Q3SParsePacket(p Packet, info ProtocolEntryInfo) (entry ServerEntry, err er
 
 	ruleByteArraySplit := bytes.Split(ruleByteArray, sepRules)
 
-	var players = Q3SParsePlayerstring(playerByteArray)
+	var players = parsePlayers(playerByteArray)
 	var rules = Q3SParseRulestring(ruleByteArraySplit)
 
 	entry = MakeServerEntry()
@@ -101,57 +116,7 @@ func Q3SParsePacket(p Packet, info ProtocolEntryInfo) (entry ServerEntry, err er
 	entry.NumClients = int64(len(players))
 	entry.Rules = rules
 
-	serverNameRule, serverNameRuleOk := info["ServerNameRule"]
-	if serverNameRuleOk {
-		serverName, _ := rules[serverNameRule]
-		entry.Name = strings.TrimSpace(serverName)
-	}
-
-	needPassRule, needPassRuleOk := info["NeedPassRule"]
-	if needPassRuleOk {
-		needPass, _ := rules[needPassRule]
-		entry.NeedPass, _ = strconv.ParseBool(needPass)
-	}
-
-	terrainRule, terrainRuleOk := info["TerrainRule"]
-	if terrainRuleOk {
-		terrain, _ := rules[terrainRule]
-		entry.Terrain = strings.TrimSpace(terrain)
-	}
-
-	modNameRule, modNameRuleOk := info["ModNameRule"]
-	if modNameRuleOk {
-		modName, _ := rules[modNameRule]
-		entry.ModName = strings.TrimSpace(modName)
-	}
-
-	gameTypeRule, gameTypeRuleOk := info["GameTypeRule"]
-	if gameTypeRuleOk {
-		gameType, _ := rules[gameTypeRule]
-		entry.GameType = strings.TrimSpace(gameType)
-	}
-
-	secureRule, secureRuleOk := info["SecureRule"]
-	if secureRuleOk {
-		secure, _ := rules[secureRule]
-		entry.Secure, _ = strconv.ParseBool(secure)
-	}
-
-	maxClientsRule, maxClientsRuleOk := info["MaxClientsRule"]
-	if maxClientsRuleOk {
-		maxClients, maxClientsOk := rules[maxClientsRule]
-		if maxClientsOk {
-			entry.MaxClients, _ = strconv.ParseInt(strings.TrimSpace(maxClients), 10, 64)
-		}
-	}
-
-	numBotsRule, numBotsRuleOk := info["NumBotsRule"]
-	if numBotsRuleOk {
-		numBots, numBotsOk := rules[numBotsRule]
-		if numBotsOk {
-			entry.NumBots, _ = strconv.ParseInt(strings.TrimSpace(numBots), 10, 64)
-		}
-	}
+	ApplyRuleMappings(&entry, rules, info)
 
 	return entry, nil
 }
@@ -0,0 +1,65 @@
+package grokstat
+
+import "strconv"
+
+func QWSMakeProtocolTemplate() ProtocolEntry {
+	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: MakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "status"}}, HandlerFunc: func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+		return SimpleReceiveHandler(QWSParsePacket, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	}, ResponseMatchFunc: Q3SMatchResponse, HttpProtocol: "udp", ResponseType: "Server info"}, Information: ProtocolEntryInfo{"Name": "QuakeWorld", "PreludeStarter": "\xFF\xFF\xFF\xFF", "RequestPreludeTemplate": "{{.PreludeStarter}}status\n", "headerTemplate": "{{.PreludeStarter}}n", "ServerNameRule": "hostname", "NeedPassRule": "needpass", "TerrainRule": "map", "ModNameRule": "*gamedir", "MaxClientsRule": "maxclients", "DefaultRequestPort": "27500"}}
+}
+
+// Splits the line on spaces outside of the double quotes. The quotes are removed.
+func splitQuotedFields(line []byte) []string {
+	fields := []string{}
+	field := []byte{}
+	inQuotes, inField := false, false
+	for _, c := range line {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+			inField = true
+		case c == ' ' && !inQuotes:
+			if inField {
+				fields = append(fields, string(field))
+				field = []byte{}
+				inField = false
+			}
+		default:
+			field = append(field, c)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, string(field))
+	}
+	return fields
+}
+
+// Parses the player lines: user id, frags, connection time in minutes, ping, quoted name and skin, top and bottom colours.
+func QWSParsePlayerstring(arr [][]byte) []PlayerEntry {
+	var v = []PlayerEntry{}
+	for _, b := range arr {
+		s := splitQuotedFields(b)
+		if len(s) < 5 {
+			continue
+		}
+		e := MakePlayerEntry()
+		e.Name = s[4]
+		e.Ping, _ = strconv.ParseInt(s[3], 10, 64)
+		e.Info["userid"] = s[0]
+		e.Info["frags"] = s[1]
+		e.Info["time"] = s[2]
+		for i, key := range []string{"skin", "topcolor", "bottomcolor"} {
+			if len(s) > 5+i {
+				e.Info[key] = s[5+i]
+			}
+		}
+		v = append(v, e)
+	}
+	return v
+}
+
+// Parses the response from QuakeWorld server
+func QWSParsePacket(p Packet, info ProtocolEntryInfo) (entry ServerEntry, err error) {
+	return parseQuakeStatus(p, info, QWSParsePlayerstring)
+}
@@ -0,0 +1,20 @@
+package grokstat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQWSParsePacket(t *testing.T) {
+	s1 := []byte("\xFF\xFF\xFF\xFFn\\maxclients\\16\\map\\dm4\\hostname\\Grok QW\\*gamedir\\qw\n12 25 14 48 \"Grok Stat\" \"base\" 4 11\n13 -9999 2 0 \"\\s\\spec\" \"\" 0 0\n")
+	info := QWSMakeProtocolTemplate().Information
+	expectation := ServerEntry{Name: "Grok QW", Terrain: "dm4", ModName: "qw", NumClients: 2, MaxClients: 16, Players: []PlayerEntry{{Name: "Grok Stat", Ping: 48, Info: map[string]string{"userid": "12", "frags": "25", "time": "14", "skin": "base", "topcolor": "4", "bottomcolor": "11"}}, {Name: "\\s\\spec", Ping: 0, Info: map[string]string{"userid": "13", "frags": "-9999", "time": "2", "skin": "", "topcolor": "0", "bottomcolor": "0"}}}, Rules: map[string]string{"maxclients": "16", "map": "dm4", "hostname": "Grok QW", "*gamedir": "qw"}}
+
+	result, resultErr := QWSParsePacket(Packet{Data: s1}, info)
+	if resultErr != nil {
+		t.Fatal(resultErr)
+	}
+	if !reflect.DeepEqual(expectation, result) {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
@@ -1,32 +1,106 @@
-package main
+package grokstat
 
 import (
 	"bytes"
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+const STEAM_SENTINEL_ADDR = "0.0.0.0:0"
+
+var SteamRegions = map[string]byte{
+	"us-east":       0x00,
+	"us-west":       0x01,
+	"south-america": 0x02,
+	"europe":        0x03,
+	"asia":          0x04,
+	"australia":     0x05,
+	"middle-east":   0x06,
+	"africa":        0x07,
+	"all":           0xFF,
+}
+
+type steamQueryState struct {
+	pages int
+	seen  map[string]struct{}
+}
+
+// Keeps track of the pagination progress for each master server queried by one query.
+type SteamQueryStateCollection struct {
+	sync.Mutex
+	data map[string]*steamQueryState
+}
+
+func MakeSteamQueryStateCollection() *SteamQueryStateCollection {
+	return &SteamQueryStateCollection{data: map[string]*steamQueryState{}}
+}
+
+// Registers the received page and returns the page count along with the servers which have not been seen before.
+func (c *SteamQueryStateCollection) AddPage(remoteAddr string, servers []string) (int, []string) {
+	c.Lock()
+	defer c.Unlock()
+	state, exists := c.data[remoteAddr]
+	if !exists {
+		state = &steamQueryState{seen: map[string]struct{}{}}
+		c.data[remoteAddr] = state
+	}
+	state.pages++
+
+	newServers := []string{}
+	for _, server := range servers {
+		if _, seen := state.seen[server]; seen {
+			continue
+		}
+		state.seen[server] = struct{}{}
+		newServers = append(newServers, server)
+	}
+	return state.pages, newServers
+}
+
 func STEAMMakeProtocolTemplate() ProtocolEntry {
-	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: MakeSteamPayload, RequestPackets: []RequestPacket{RequestPacket{Id: "STEAM_REQUEST"}}, HandlerFunc: SteamHandler, HttpProtocol: "udp", ResponseType: "Server list"}, Information: ProtocolEntryInfo{"Name": "Steam Master", "DefaultRequestPort": "27011", "ResponsePreludeTemplate": "\xFF\xFF\xFF\xFF\x66\x0A"}}
+	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: MakeSteamPayload, RequestPackets: []RequestPacket{RequestPacket{Id: "STEAM_REQUEST"}}, PrepareQueryFunc: STEAMPrepareQuery, ResponseMatchFunc: MatchResponsePrelude, HttpProtocol: "udp", ResponseType: "Server list"}, Information: ProtocolEntryInfo{"Name": "Steam Master", "DefaultRequestPort": "27011", "ResponsePreludeTemplate": "\xFF\xFF\xFF\xFF\x66\x0A", "Region": "all", "Filter": "", "MaxPages": "100"}}
 }
 
-func makeSteamRequest(lastIp string) []byte {
-	return []byte(fmt.Sprintf("\x31\xff%s\x00\x00", lastIp))
+// Sets up the handler for one query. The pagination progress is kept for the masters queried by it.
+func STEAMPrepareQuery(base *ProtocolEntryBase) {
+	queryStates := MakeSteamQueryStateCollection()
+	base.HandlerFunc = func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) []Packet {
+		return SteamHandler(queryStates, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	}
+}
+
+// Converts the region name or number into the region code used by Steam master server.
+func SteamRegionCode(region string) byte {
+	if code, exists := SteamRegions[strings.ToLower(region)]; exists {
+		return code
+	}
+	code, err := strconv.ParseUint(region, 0, 8)
+	if err != nil {
+		return SteamRegions["all"]
+	}
+	return byte(code)
+}
+
+func makeSteamRequest(lastIp string, protocolInfo ProtocolEntryInfo) []byte {
+	return []byte(fmt.Sprintf("\x31%s%s\x00%s\x00", string([]byte{SteamRegionCode(protocolInfo["Region"])}), lastIp, protocolInfo["Filter"]))
 }
 
 func MakeSteamRequestPacket(packetId string, protocolInfo ProtocolEntryInfo) Packet {
-	return Packet{Data: makeSteamRequest("0.0.0.0:0")}
+	return Packet{Data: makeSteamRequest(STEAM_SENTINEL_ADDR, protocolInfo)}
 }
 
 func MakeSteamPayload(packet Packet, protocolEntryInfo ProtocolEntryInfo) Packet {
 	if packet.Id == "STEAM_REQUEST" {
-		packet.Data = makeSteamRequest("0.0.0.0:0")
+		packet.Data = makeSteamRequest(STEAM_SENTINEL_ADDR, protocolEntryInfo)
 	}
 
 	return packet
 }
 
-func SteamHandler(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+func SteamHandler(queryStates *SteamQueryStateCollection, packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
 	sendPackets = make([]Packet, 0)
 
 	protocolId := packet.ProtocolId
@@ -51,50 +125,63 @@ func SteamHandler(packet Packet, protocolCollection *ProtocolCollection, message
 	if preludeOk {
 		bodyOk := math.Mod(float64(len(body)), 6.0) == 0
 		if !bodyOk {
-			messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("STEAM - %s - Invalid body length.", remoteIp)}
+			messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - Invalid body length.", protocolId, remoteIp)}
+			serverEntryChan <- MakeErrorServerEntry(remoteIp, protocolId, InvalidResponseLength)
 			return sendPackets
 		}
 
-		pairList := []HostProtocolIdPair{}
+		servers := []string{}
+		var lastIp string
+		var complete bool
 
 		ipBuf := bytes.NewBuffer(body)
-		for {
+		for ipBuf.Len() > 0 {
 			ipAddrRaw := ipBuf.Next(6)
+			if bytes.Equal(ipAddrRaw, make([]byte, 6)) {
+				complete = true
+				break
+			}
 			ipAddr, ipErr := ParseBinaryIPv4Entry(ipAddrRaw, false)
 			if ipErr != nil {
-				messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("STEAM - %s - Error parsing IP in response.", remoteIp)}
-				return sendPackets
-			}
-			masterOf, mOk := protocolInfo["MasterOf"]
-			if mOk {
-				pair := HostProtocolIdPair{RemoteAddr: ipAddr, ProtocolId: masterOf}
-				protocolMappingInChan <- pair
-				pairList = append(pairList, pair)
-			}
-			if ipBuf.Len() == 0 {
-				break
+				messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - Error parsing IP in response.", protocolId, remoteIp)}
+				continue
 			}
+			servers = append(servers, ipAddr)
+			lastIp = ipAddr
 		}
 
-		lastIp := pairList[len(pairList)-1].RemoteAddr
+		pageNum, newServers := queryStates.AddPage(remoteIp, servers)
+		maxPages, _ := strconv.Atoi(protocolInfo["MaxPages"])
 
-		messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("STEAM - %s - Last IP: %s.", remoteIp, lastIp)}
-		if lastIp == "0.0.0.0:0" {
-			messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: "STEAM: Query complete."}
+		messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - Page %d, %d new servers, last IP: %s.", protocolId, remoteIp, pageNum, len(newServers), lastIp)}
+		if complete || lastIp == "" {
+			messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - Query complete.", protocolId, remoteIp)}
+		} else if maxPages > 0 && pageNum >= maxPages {
+			messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - Page limit of %d reached.", protocolId, remoteIp, maxPages)}
 		} else {
-			sendPacket := Packet{Id: "STEAM_REQUEST", Data: []byte(makeSteamRequest(lastIp)), RemoteAddr: remoteIp, ProtocolId: "STEAM"}
+			sendPacket := Packet{Id: "STEAM_REQUEST", Type: packet.Type, Data: makeSteamRequest(lastIp, protocolInfo), RemoteAddr: remoteIp, ProtocolId: protocolId}
 			sendPackets = append(sendPackets, sendPacket)
 		}
 
-		for _, pair := range pairList {
-			sendPackets = append(sendPackets, MakeSendPackets(pair, protocolCollection)...)
+		masterOf, mOk := protocolInfo["MasterOf"]
+		if mOk {
+			for _, ipAddr := range newServers {
+				pair := HostProtocolIdPair{RemoteAddr: ipAddr, ProtocolId: masterOf}
+				protocolMappingInChan <- pair
+				sendPackets = append(sendPackets, MakeSendPackets(pair, protocolCollection)...)
+			}
 		}
 
-		masterServerEntry := ServerEntry{Protocol: packet.ProtocolId, Host: packet.RemoteAddr, Name: "Steam Master Server"}
+		masterServerEntry := MakeServerEntry()
+		masterServerEntry.Protocol = protocolId
+		masterServerEntry.Host = remoteIp
+		masterServerEntry.Name = fmt.Sprintf("%s Server", protocolInfo["Name"])
+		masterServerEntry.Status = 200
 		serverEntryChan <- masterServerEntry
 
 	} else {
-		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("STEAM - %s - Prelude Error", remoteIp)}
+		messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - Prelude Error", protocolId, remoteIp)}
+		serverEntryChan <- MakeErrorServerEntry(remoteIp, protocolId, InvalidResponseHeader)
 	}
 	return sendPackets
 }
@@ -0,0 +1,64 @@
+package grokstat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMakeSteamRequest(t *testing.T) {
+	s1 := ProtocolEntryInfo{"Region": "europe", "Filter": `\appid\440\empty\1`}
+	expectation := "\x31\x031.2.3.4:27015\x00\\appid\\440\\empty\\1\x00"
+
+	result := string(makeSteamRequest("1.2.3.4:27015", s1))
+
+	if result != expectation {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestSteamQueryStateCollection(t *testing.T) {
+	var err error
+	s1 := [][]string{{"1.2.3.4:27015", "1.2.3.5:27015"}, {"1.2.3.5:27015", "1.2.3.6:27015"}}
+	expectation := []string{"1.2.3.6:27015"}
+
+	queryStates := MakeSteamQueryStateCollection()
+	queryStates.AddPage("127.0.0.1:27011", s1[0])
+	pageNum, result := queryStates.AddPage("127.0.0.1:27011", s1[1])
+
+	if pageNum != 2 || len(result) != len(expectation) {
+		err = CompError
+	} else {
+		for i := range result {
+			if result[i] != expectation[i] {
+				err = CompError
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestSteamQueriesPaginateSeparately(t *testing.T) {
+	s1 := []byte("\xFF\xFF\xFF\xFF\x66\x0A\x7F\x00\x00\x01\x00\x01\x7F\x00\x00\x01\x00\x02")
+	expectation := []string{"STEAM_REQUEST", "ping", "ping"}
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "steam", Template: "STEAM", Overrides: map[string]string{"MasterOf": "mumbles"}}, ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+	messageChan := make(chan ConsoleMsg, 10)
+	protocolMappingInChan := make(chan HostProtocolIdPair, 10)
+	serverEntryChan := make(chan ServerEntry, 10)
+
+	for i := 0; i < 2; i++ {
+		queryColl := protColl.ForQuery()
+		protocol, _ := queryColl.Get("steam")
+		result := []string{}
+		for _, packet := range protocol.Base.HandlerFunc(Packet{Data: s1, RemoteAddr: "127.0.0.1:27011", ProtocolId: "steam"}, queryColl, messageChan, protocolMappingInChan, serverEntryChan) {
+			result = append(result, packet.Id)
+		}
+		if !reflect.DeepEqual(result, expectation) {
+			t.Errorf(ErrorOut(expectation, result))
+		}
+	}
+}
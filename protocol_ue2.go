@@ -0,0 +1,241 @@
+package grokstat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+const (
+	UE2_INFO_RESPONSE    = 0x00
+	UE2_RULES_RESPONSE   = 0x01
+	UE2_PLAYERS_RESPONSE = 0x02
+	UE2_COLOR_CODE       = 0x1B
+)
+
+func UE2MakeProtocolTemplate() ProtocolEntry {
+	return ProtocolEntry{Base: ProtocolEntryBase{MakePayloadFunc: UE2MakePayload, RequestPackets: []RequestPacket{RequestPacket{Id: "info"}, RequestPacket{Id: "rules"}, RequestPacket{Id: "players"}}, PrepareQueryFunc: UE2PrepareQuery, ResponseIdFunc: UE2ResponseId, ResponseMatchFunc: func(packet Packet, protocolInfo ProtocolEntryInfo) bool {
+		return UE2ResponseId(packet, protocolInfo) != ""
+	}, HttpProtocol: "udp", ResponseType: "Server info"}, Information: ProtocolEntryInfo{"Name": "Unreal Engine 2 Server", "RequestPreludeTemplate": "\x79\x00\x00\x00", "ResponsePreludeTemplate": "\x80\x00\x00\x00", "NeedPassRule": "GamePassword", "DefaultRequestPort": "7777", "QueryPortOffset": "10"}}
+}
+
+// Sets up the handler for one query. The players response may span several packets, the players received so far are sent with each of them.
+func UE2PrepareQuery(base *ProtocolEntryBase) {
+	players := MakeUE2PlayerCollection()
+	base.HandlerFunc = func(packet Packet, protocolCollection *ProtocolCollection, messageChan chan<- ConsoleMsg, protocolMappingInChan chan<- HostProtocolIdPair, serverEntryChan chan<- ServerEntry) (sendPackets []Packet) {
+		return SimpleReceiveHandler(func(packet Packet, protocolInfo ProtocolEntryInfo) (ServerEntry, error) {
+			entry, err := UE2parsePacket(packet, protocolInfo)
+			if err == nil && len(entry.Players) > 0 {
+				entry.Players = players.Add(packet.RemoteAddr, entry.Players)
+			}
+			return entry, err
+		}, packet, protocolCollection, messageChan, protocolMappingInChan, serverEntryChan)
+	}
+}
+
+// Collects the players keyed by remote address. Players are told apart by their id, so repeated packets do not list them twice.
+type UE2PlayerCollection struct {
+	sync.Mutex
+	data map[string][]PlayerEntry
+}
+
+func MakeUE2PlayerCollection() *UE2PlayerCollection {
+	return &UE2PlayerCollection{data: map[string][]PlayerEntry{}}
+}
+
+// Stores the players and returns the players received so far.
+func (c *UE2PlayerCollection) Add(remoteAddr string, players []PlayerEntry) []PlayerEntry {
+	c.Lock()
+	defer c.Unlock()
+	stored := c.data[remoteAddr]
+	for _, player := range players {
+		exists := false
+		for _, v := range stored {
+			if v.Info["id"] == player.Info["id"] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			stored = append(stored, player)
+		}
+	}
+	c.data[remoteAddr] = stored
+	return append([]PlayerEntry{}, stored...)
+}
+
+var ue2RequestTypes = map[string]byte{"info": UE2_INFO_RESPONSE, "rules": UE2_RULES_RESPONSE, "players": UE2_PLAYERS_RESPONSE}
+
+func UE2MakePayload(packet Packet, protocolInfo ProtocolEntryInfo) Packet {
+	packet.Data = append([]byte(ParseTemplate(protocolInfo["RequestPreludeTemplate"], protocolInfo)), ue2RequestTypes[packet.Id])
+	return packet
+}
+
+// Returns the id of the request the response answers according to the query type following the prelude.
+func UE2ResponseId(packet Packet, protocolInfo ProtocolEntryInfo) string {
+	body, preludeOk := CheckPrelude(packet.Data, []byte(ParseTemplate(protocolInfo["ResponsePreludeTemplate"], protocolInfo)))
+	if !preludeOk || len(body) == 0 {
+		return ""
+	}
+	for requestId, requestType := range ue2RequestTypes {
+		if body[0] == requestType {
+			return requestId
+		}
+	}
+	return ""
+}
+
+// Reads the length-prefixed string. The length includes the null terminator, its high bit marks UCS-2 strings whose length is counted in characters.
+// Single-byte strings are Latin-1. Colour codes, an escape character followed by the RGB bytes, are removed.
+func ReadUE2String(buf *bytes.Buffer) (string, error) {
+	length, err := buf.ReadByte()
+	if err != nil {
+		return "", InvalidResponseLength
+	}
+
+	var chars []rune
+	if length&0x80 != 0 {
+		raw := buf.Next(2 * int(length&0x7F))
+		if len(raw) != 2*int(length&0x7F) {
+			return "", InvalidResponseLength
+		}
+		units := make([]uint16, len(raw)/2)
+		for i := range units {
+			units[i] = binary.LittleEndian.Uint16(raw[2*i:])
+		}
+		chars = utf16.Decode(units)
+	} else {
+		raw := buf.Next(int(length))
+		if len(raw) != int(length) {
+			return "", InvalidResponseLength
+		}
+		for _, b := range raw {
+			chars = append(chars, rune(b))
+		}
+	}
+
+	var out bytes.Buffer
+	for i := 0; i < len(chars); i++ {
+		switch chars[i] {
+		case 0:
+		case UE2_COLOR_CODE:
+			i += 3
+		default:
+			out.WriteRune(chars[i])
+		}
+	}
+	return out.String(), nil
+}
+
+func readUE2Int(buf *bytes.Buffer) (int64, error) {
+	raw := buf.Next(4)
+	if len(raw) != 4 {
+		return 0, InvalidResponseLength
+	}
+	return int64(int32(binary.LittleEndian.Uint32(raw))), nil
+}
+
+// Parses the info, rules or players response. Each response makes up a part of the server entry, the parts are merged by the query.
+func UE2parsePacket(packet Packet, protocolInfo ProtocolEntryInfo) (entry ServerEntry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			entry = MakeServerEntry()
+			err = MalformedPacket
+		}
+	}()
+	body, preludeOk := CheckPrelude(packet.Data, []byte(ParseTemplate(protocolInfo["ResponsePreludeTemplate"], protocolInfo)))
+	if !preludeOk || len(body) == 0 {
+		return MakeServerEntry(), InvalidResponseHeader
+	}
+
+	buf := bytes.NewBuffer(body[1:])
+	switch body[0] {
+	case UE2_INFO_RESPONSE:
+		return UE2parseInfo(buf)
+	case UE2_RULES_RESPONSE:
+		return UE2parseRules(buf, protocolInfo)
+	case UE2_PLAYERS_RESPONSE:
+		return UE2parsePlayers(buf)
+	default:
+		return MakeServerEntry(), InvalidServerHeader
+	}
+}
+
+func UE2parseInfo(buf *bytes.Buffer) (entry ServerEntry, err error) {
+	entry = MakeServerEntry()
+	var serverIp, serverName, mapName, gameType string
+	var serverId, gamePort, queryPort, numPlayers, maxPlayers int64
+	for _, field := range []interface{}{&serverId, &serverIp, &gamePort, &queryPort, &serverName, &mapName, &gameType, &numPlayers, &maxPlayers} {
+		switch v := field.(type) {
+		case *string:
+			*v, err = ReadUE2String(buf)
+		case *int64:
+			*v, err = readUE2Int(buf)
+		}
+		if err != nil {
+			return MakeServerEntry(), err
+		}
+	}
+
+	entry.Name = strings.TrimSpace(serverName)
+	entry.Terrain = mapName
+	entry.GameType = gameType
+	entry.NumClients = numPlayers
+	entry.MaxClients = maxPlayers
+	entry.Rules["serverid"] = strconv.FormatInt(serverId, 10)
+	entry.Rules["gameport"] = strconv.FormatInt(gamePort, 10)
+	entry.Rules["queryport"] = strconv.FormatInt(queryPort, 10)
+	return entry, nil
+}
+
+// Parses the rules. Repeated keys, such as the mutator list, are joined with commas.
+func UE2parseRules(buf *bytes.Buffer, protocolInfo ProtocolEntryInfo) (entry ServerEntry, err error) {
+	entry = MakeServerEntry()
+	for buf.Len() > 0 {
+		key, kErr := ReadUE2String(buf)
+		if kErr != nil {
+			return MakeServerEntry(), InvalidRuleString
+		}
+		value, vErr := ReadUE2String(buf)
+		if vErr != nil {
+			return MakeServerEntry(), InvalidRuleString
+		}
+		if existing, exists := entry.Rules[key]; exists {
+			value = existing + ", " + value
+		}
+		entry.Rules[key] = value
+	}
+	ApplyRuleMappings(&entry, entry.Rules, protocolInfo)
+	return entry, nil
+}
+
+func UE2parsePlayers(buf *bytes.Buffer) (entry ServerEntry, err error) {
+	entry = MakeServerEntry()
+	for buf.Len() > 0 {
+		player := MakePlayerEntry()
+		var id, score, statsId int64
+		if id, err = readUE2Int(buf); err != nil {
+			return MakeServerEntry(), InvalidPlayerStringLength
+		}
+		if player.Name, err = ReadUE2String(buf); err != nil {
+			return MakeServerEntry(), InvalidPlayerStringLength
+		}
+		if player.Ping, err = readUE2Int(buf); err != nil {
+			return MakeServerEntry(), InvalidPlayerStringLength
+		}
+		if score, err = readUE2Int(buf); err != nil {
+			return MakeServerEntry(), InvalidPlayerStringLength
+		}
+		if statsId, err = readUE2Int(buf); err != nil {
+			return MakeServerEntry(), InvalidPlayerStringLength
+		}
+		player.Info["id"] = strconv.FormatInt(id, 10)
+		player.Info["score"] = strconv.FormatInt(score, 10)
+		player.Info["statsid"] = strconv.FormatInt(statsId, 10)
+		entry.Players = append(entry.Players, player)
+	}
+	return entry, nil
+}
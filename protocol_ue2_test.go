@@ -0,0 +1,88 @@
+package grokstat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReadUE2String(t *testing.T) {
+	s1 := [][]byte{[]byte("\x05Grok\x00"), []byte("\x09\x1B\xFF\x00\x00Gr\xF6k\x00"), []byte("\x85G\x00r\x00\xF6\x00k\x00\x00\x00")}
+	expectation := []string{"Grok", "Grök", "Grök"}
+
+	result := []string{}
+	for _, b := range s1 {
+		s, err := ReadUE2String(bytes.NewBuffer(b))
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		result = append(result, s)
+	}
+
+	if !reflect.DeepEqual(expectation, result) {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+
+	if _, err := ReadUE2String(bytes.NewBuffer([]byte("\x09Grok\x00"))); err != InvalidResponseLength {
+		t.Errorf(ErrorOut(InvalidResponseLength, err))
+	}
+}
+
+func TestQueryUE2(t *testing.T) {
+	conn, lErr := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	defer conn.Close()
+
+	responses := map[byte][]string{
+		UE2_INFO_RESPONSE:    {"\x80\x00\x00\x00\x00\x01\x00\x00\x00\x01\x00\x61\x1E\x00\x00\x6B\x1E\x00\x00\x0C\x1B\x00\xFF\x00Grok UT\x00\x0ADM-Rankin\x00\x0CxDeathMatch\x00\x01\x00\x00\x00\x10\x00\x00\x00"},
+		UE2_RULES_RESPONSE:   {"\x80\x00\x00\x00\x01\x0DGamePassword\x00\x05True\x00\x08Mutator\x00\x09InstaGib\x00\x08Mutator\x00\x09QuadJump\x00"},
+		UE2_PLAYERS_RESPONSE: {"\x80\x00\x00\x00\x02\x07\x00\x00\x00\x05Grok\x00\x28\x00\x00\x00\x0F\x00\x00\x00\x00\x00\x00\x00", "\x80\x00\x00\x00\x02\x08\x00\x00\x00\x05Stat\x00\x32\x00\x00\x00\x03\x00\x00\x00\x00\x00\x00\x00"},
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n == 5 && string(buf[:4]) == "\x79\x00\x00\x00" {
+				for _, response := range responses[buf[4]] {
+					conn.WriteToUDP([]byte(response), addr)
+				}
+			}
+		}
+	}()
+
+	gamePort := conn.LocalAddr().(*net.UDPAddr).Port - 10
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "ue2", Template: "UE2"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: net.JoinHostPort("127.0.0.1", strconv.Itoa(gamePort)), ProtocolId: "ue2"}}
+	expectation := fmt.Sprint(200, " Grok UT DM-Rankin xDeathMatch ", 1, 16, true, " InstaGib, QuadJump [Grok Stat]")
+
+	result, err := Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Servers) != 1 {
+		t.Fatalf(ErrorOut(expectation, result.Servers))
+	}
+	entry := result.Servers[0]
+	names := []string{}
+	for _, player := range entry.Players {
+		names = append(names, player.Name)
+	}
+	sort.Strings(names)
+	if fmt.Sprint(entry.Status, " ", entry.Name, " ", entry.Terrain, " ", entry.GameType, " ", entry.NumClients, entry.MaxClients, entry.NeedPass, " ", entry.Rules["Mutator"], " ", names) != expectation {
+		t.Errorf(ErrorOut(expectation, entry))
+	}
+	if entry.Host != conn.LocalAddr().String() {
+		t.Errorf(ErrorOut(conn.LocalAddr().String(), entry.Host))
+	}
+}
@@ -1,6 +1,21 @@
-package main
+package grokstat
 
-import "sync"
+import (
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+type ConfigFile struct {
+	Protocols []ProtocolConfig `toml:"Protocols"`
+}
+
+// Reads the protocol configuration from TOML file.
+func LoadConfigFile(path string) (ConfigFile, error) {
+	var configInstance ConfigFile
+	_, err := toml.DecodeFile(path, &configInstance)
+	return configInstance, err
+}
 
 type ProtocolConfig struct {
 	Id        string            `toml:"Id"`
@@ -43,6 +58,40 @@ func (c *ProtocolCollection) Map() map[string]ProtocolEntry {
 	return m
 }
 
+// Applies information overrides to a loaded protocol entry.
+func (c *ProtocolCollection) Override(k string, overrides map[string]string) bool {
+	c.Lock()
+	defer c.Unlock()
+	v, exists := c.data[k]
+	if !exists {
+		return false
+	}
+	entry := MakeProtocolEntry(v)
+	entry.Id = v.Id
+	for k1, v1 := range overrides {
+		entry.Information[k1] = v1
+	}
+	c.data[k] = entry
+	return true
+}
+
+// Returns a collection with the same protocol entries. Overrides applied to the copy do not affect the original.
+func (c *ProtocolCollection) Copy() *ProtocolCollection {
+	return &ProtocolCollection{data: c.Map()}
+}
+
+// Returns a copy of the collection in which the protocols keeping state between responses have their own functions. Query uses it so the state is not shared with other queries.
+func (c *ProtocolCollection) ForQuery() *ProtocolCollection {
+	m := c.Map()
+	for k, v := range m {
+		if v.Base.PrepareQueryFunc != nil {
+			v.Base.PrepareQueryFunc(&v.Base)
+			m[k] = v
+		}
+	}
+	return &ProtocolCollection{data: m}
+}
+
 func MakeProtocolCollection() *ProtocolCollection {
 	return &ProtocolCollection{data: map[string]ProtocolEntry{}}
 }
@@ -62,6 +111,13 @@ func LoadProtocols(configData []ProtocolConfig) *ProtocolCollection {
 	templates["STEAM"] = STEAMMakeProtocolTemplate
 	templates["A2S"] = A2SMakeProtocolTemplate
 	templates["MUMBLES"] = MUMBLESMakeProtocolTemplate
+	templates["MINECRAFT"] = MINECRAFTMakeProtocolTemplate
+	templates["GS1"] = GS1MakeProtocolTemplate
+	templates["GS2"] = GS2MakeProtocolTemplate
+	templates["GS4"] = GS4MakeProtocolTemplate
+	templates["QWS"] = QWSMakeProtocolTemplate
+	templates["NQS"] = NQSMakeProtocolTemplate
+	templates["UE2"] = UE2MakeProtocolTemplate
 
 	var protMap = make(map[string]ProtocolEntry, len(templates))
 	for k, v := range templates {
@@ -0,0 +1,508 @@
+/*
+Package grokstat queries game servers for various information: server list, player count, active map etc.
+
+Protocols are loaded from the configuration with LoadProtocols. Query sends the requests to the hosts asynchronously, parses the responses and returns them as ServerEntry values along with the errors encountered for each host.
+*/
+package grokstat
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ServerResponseStruct struct {
+	Hostname    string
+	ResponseMap map[string]Packet
+	ResponseErr error
+}
+
+type PacketErrorPair struct {
+	Packet Packet
+	Error  error
+}
+
+// QueryOptions tune the behavior of Query.
+type QueryOptions struct {
+	// Protocol collection used to build the requests and parse the responses.
+	Protocols *ProtocolCollection
+	// Optional channel receiving console messages.
+	MessageChan chan<- ConsoleMsg
+	// Overall query duration limit. Zero means no limit.
+	Timeout time.Duration
+	// The query ends once no packets have been sent or received for this duration. Defaults to DEFAULT_IDLE_TIMEOUT.
+	IdleTimeout time.Duration
+	// Number of ping probes sent to each requested server. Round-trip time statistics are reported when more than one probe is sent.
+	Probes int
+	// Interval between the ping probes. Defaults to DEFAULT_PROBE_INTERVAL.
+	ProbeInterval time.Duration
+	// Packets sent per second. Defaults to DEFAULT_SEND_RATE, negative means no limit.
+	SendRate int
+	// Number of hosts queried at once. Defaults to DEFAULT_MAX_IN_FLIGHT, negative means no limit.
+	MaxInFlight int
+	// Optional counters updated with the packets sent and received and the parse errors.
+	Counters *QueryCounters
+	// Optional handler receiving each server entry as soon as all requests to the server have been answered or given up. The entries not finalized by the end of the query are passed before Query returns. Calls are serialized.
+	EntryHandler func(ServerEntry)
+
+	udpSockets *UDPSockets
+}
+
+// QueryResult holds the entries for every requested or discovered host and the errors for hosts which could not be queried.
+type QueryResult struct {
+	Servers []ServerEntry
+	Errors  map[string]error
+}
+
+// Makes the host list out of protocol id to hosts map.
+func MakeHostProtocolIdPairs(hostMap map[string][]string) []HostProtocolIdPair {
+	hosts := []HostProtocolIdPair{}
+	for protocolId, hostList := range hostMap {
+		for _, host := range RemoveDuplicates(hostList) {
+			hosts = append(hosts, HostProtocolIdPair{RemoteAddr: host, ProtocolId: protocolId})
+		}
+	}
+	return hosts
+}
+
+func MakePacketErrorPair(hosts []HostProtocolIdPair, protColl *ProtocolCollection) (packErrPairs []PacketErrorPair) {
+	packErrPairs = []PacketErrorPair{}
+
+	for _, hostpair := range hosts {
+		var hostpackets = []Packet{}
+		var err error
+
+		protocolId := hostpair.ProtocolId
+		protocol, protocolExists := protColl.Get(protocolId)
+		if protocolExists {
+			host, port := SplitRemoteAddr(hostpair.RemoteAddr, protocol.Information["DefaultRequestPort"])
+			ipAddr, rErr := net.ResolveIPAddr("ip", host)
+			if rErr == nil {
+				addrFinal := net.JoinHostPort(ipAddr.String(), QueryPort(port, protocol.Information))
+
+				reqPackets := MakeSendPackets(HostProtocolIdPair{RemoteAddr: addrFinal, ProtocolId: protocolId, Hostname: host}, protColl)
+
+				for _, reqPacket := range reqPackets {
+					hostpackets = append(hostpackets, reqPacket)
+				}
+			} else {
+				err = rErr
+			}
+		} else {
+			err = InvalidProtocol
+		}
+
+		if err != nil {
+			packErrPairs = append(packErrPairs, PacketErrorPair{Packet: Packet{RemoteAddr: hostpair.RemoteAddr, ProtocolId: protocolId}, Error: err})
+			continue
+		}
+
+		for _, packetFinal := range hostpackets {
+			packErrPairs = append(packErrPairs, PacketErrorPair{Packet: packetFinal, Error: err})
+		}
+	}
+
+	return packErrPairs
+}
+
+var ParseIPAddr = func(ipString string, defaultPort string) map[string]string {
+	var ipStringMod string
+
+	if len(strings.Split(ipString, "://")) == 1 {
+		ipStringMod = "placeholder://" + ipString
+	} else {
+		ipStringMod = ipString
+	}
+
+	urlInfo, _ := url.Parse(ipStringMod)
+
+	result := make(map[string]string)
+	result["http_protocol"] = urlInfo.Scheme
+	result["host"] = urlInfo.Host
+
+	if len(strings.Split(result["host"], ":")) == 1 {
+		result["host"] = result["host"] + ":" + defaultPort
+	}
+
+	return result
+}
+
+// Returns the first of the specified protocols whose signature matcher accepts the response.
+func MatchPacketProtocol(packet Packet, protColl *ProtocolCollection, protocolIds []string) (string, bool) {
+	for _, protocolId := range protocolIds {
+		protocol, exists := protColl.Get(protocolId)
+		if !exists || protocol.Base.ResponseMatchFunc == nil {
+			continue
+		}
+		if protocol.Base.ResponseMatchFunc(packet, protocol.Information) {
+			return protocolId, true
+		}
+	}
+	return "", false
+}
+
+// Identifies the protocol of the response with the signature matchers of the protocols. The preferred protocols are tried first, then the rest in the order of their ids.
+func IdentifyPacketProtocol(packet Packet, protColl *ProtocolCollection, preferredIds []string) (string, bool) {
+	protocolIds := []string{}
+	for protocolId := range protColl.Map() {
+		protocolIds = append(protocolIds, protocolId)
+	}
+	sort.Strings(protocolIds)
+
+	return MatchPacketProtocol(packet, protColl, append(preferredIds, protocolIds...))
+}
+
+type pingSampleCollection struct {
+	sync.Mutex
+	data map[string][]time.Duration
+}
+
+func (c *pingSampleCollection) Add(remoteAddr string, sample time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.data[remoteAddr] = append(c.data[remoteAddr], sample)
+}
+
+func (c *pingSampleCollection) Get(remoteAddr string) []time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return c.data[remoteAddr]
+}
+
+// Re-sends the probe packets at the specified interval until the probe count is reached or the end channel is closed.
+func probeLoop(endChan <-chan struct{}, sendChan chan<- Packet, packets []Packet, count int, interval time.Duration) {
+	for i := 1; i < count; i++ {
+		select {
+		case <-time.After(interval):
+		case <-endChan:
+			return
+		}
+		for _, packet := range packets {
+			select {
+			case sendChan <- packet:
+			case <-endChan:
+				return
+			}
+		}
+	}
+}
+
+// Query sends the requests to the specified hosts and collects the parsed responses.
+// If the context is cancelled the responses collected so far are returned along with the context error.
+func Query(ctx context.Context, hosts []HostProtocolIdPair, opts QueryOptions) (result QueryResult, err error) {
+	result = QueryResult{Servers: []ServerEntry{}, Errors: map[string]error{}}
+
+	protColl := opts.Protocols
+	if protColl == nil {
+		return result, NoProtocols
+	}
+	protColl = protColl.ForQuery()
+
+	if err = ctx.Err(); err != nil {
+		return result, err
+	}
+
+	idleTimeout := opts.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DEFAULT_IDLE_TIMEOUT
+	}
+
+	probeInterval := opts.ProbeInterval
+	if probeInterval <= 0 {
+		probeInterval = DEFAULT_PROBE_INTERVAL
+	}
+
+	sendRate := opts.SendRate
+	if sendRate == 0 {
+		sendRate = DEFAULT_SEND_RATE
+	}
+	maxInFlight := opts.MaxInFlight
+	if maxInFlight == 0 {
+		maxInFlight = DEFAULT_MAX_IN_FLIGHT
+	}
+
+	queryCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		queryCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	var messageChan chan<- ConsoleMsg
+	if opts.MessageChan != nil {
+		messageChan = opts.MessageChan
+	} else {
+		discardChan := make(chan ConsoleMsg)
+		discardEndChan := make(chan struct{})
+		discardDoneChan := make(chan struct{})
+		defer func() {
+			close(discardEndChan)
+			<-discardDoneChan
+		}()
+		go func() {
+			defer close(discardDoneChan)
+			for {
+				select {
+				case <-discardChan:
+				case <-discardEndChan:
+					return
+				}
+			}
+		}()
+		messageChan = discardChan
+	}
+
+	state := MakeQueryState(opts.Counters)
+	defer state.Close()
+
+	protocolMappingInChan := state.MappingChan()
+	serverEntryChan := state.EntryChan()
+
+	receivePacketChan := make(chan Packet, 9999)
+
+	serverInitChan := make(chan struct{})
+	serverStopChan := make(chan struct{})
+
+	pingSamples := &pingSampleCollection{data: map[string][]time.Duration{}}
+
+	knownHosts := []HostProtocolIdPair{}
+	autoHosts := []HostProtocolIdPair{}
+	for _, hostpair := range hosts {
+		if hostpair.ProtocolId == AUTO_PROTOCOL_ID {
+			autoHosts = append(autoHosts, hostpair)
+		} else {
+			knownHosts = append(knownHosts, hostpair)
+		}
+	}
+
+	streaming := opts.EntryHandler != nil
+	var entryHandlerMutex sync.Mutex
+	emitEntry := func(entry ServerEntry) {
+		entryHandlerMutex.Lock()
+		defer entryHandlerMutex.Unlock()
+		opts.EntryHandler(entry)
+	}
+
+	completeEntry := func(entry ServerEntry) ServerEntry {
+		sort.Strings(entry.DetectedProtocols)
+		if entry.Error == nil {
+			if entry.Message == "" {
+				entry.Message = OK.Error()
+			}
+			if samples := pingSamples.Get(entry.Host); len(samples) > 0 {
+				pingStats := MakePingStats(samples)
+				entry.Ping = int64(pingStats.Avg + 0.5)
+				if opts.Probes > 1 {
+					entry.PingStats = &pingStats
+				}
+			}
+		}
+		return entry
+	}
+
+	packErrPairs, autoCandidates := MakeAutoProbes(autoHosts, protColl)
+	packErrPairs = append(MakePacketErrorPair(knownHosts, protColl), packErrPairs...)
+	sendPacketChan := make(chan Packet, len(packErrPairs)+9999)
+
+	parseHandlerWrapper := func(packet Packet) (sendPackets []Packet) {
+		sendPackets = make([]Packet, 0)
+		if packet.RoundTripTime > 0 {
+			pingSamples.Add(packet.RemoteAddr, packet.RoundTripTime)
+		}
+		var protocolName string
+		protocolMappingName, pOk := state.ProtocolOf(packet.RemoteAddr)
+		if autoIds, isAuto := autoCandidates[packet.RemoteAddr]; isAuto {
+			preferredIds := autoIds
+			if pOk && protocolMappingName != AUTO_PROTOCOL_ID {
+				preferredIds = append([]string{protocolMappingName}, autoIds...)
+			}
+			protocolDetectedName, dOk := MatchPacketProtocol(packet, protColl, preferredIds)
+			if dOk {
+				protocolName = protocolDetectedName
+				state.AddDetected(packet.RemoteAddr, protocolName)
+				if protocolMappingName == AUTO_PROTOCOL_ID {
+					messageChan <- ConsoleMsg{Type: MSG_MINOR, Message: fmt.Sprintf("%s - %s - Protocol detected.", protocolName, packet.RemoteAddr)}
+					protocolMappingInChan <- HostProtocolIdPair{RemoteAddr: packet.RemoteAddr, ProtocolId: protocolName}
+				}
+			} else {
+				messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - Discarded response of %d bytes matching no probed protocol.", AUTO_PROTOCOL_ID, packet.RemoteAddr, len(packet.Data))}
+			}
+		} else if pOk {
+			protocolName = protocolMappingName
+		} else {
+			protocolIdentifiedName, iOk := IdentifyPacketProtocol(packet, protColl, state.ProtocolsByAffinity(packet.RemoteAddr))
+			if iOk {
+				protocolName = protocolIdentifiedName
+				messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - Identified response from unknown address.", protocolName, packet.RemoteAddr)}
+				protocolMappingInChan <- HostProtocolIdPair{RemoteAddr: packet.RemoteAddr, ProtocolId: protocolName}
+			} else {
+				messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - Discarded unidentified packet of %d bytes.", packet.RemoteAddr, len(packet.Data))}
+			}
+		}
+		if opts.Counters != nil {
+			if protocolName != "" {
+				opts.Counters.PacketReceived(protocolName)
+			} else {
+				opts.Counters.PacketReceived(UNKNOWN_PROTOCOL_ID)
+			}
+		}
+		if protocolName != "" {
+			protocolEntry, protocolExists := protColl.Get(protocolName)
+			if protocolExists {
+				packet.ProtocolId = protocolName
+				handlerFunc := protocolEntry.Base.HandlerFunc
+
+				if handlerFunc != nil {
+					sendPackets = handlerFunc(packet, protColl, messageChan, protocolMappingInChan, serverEntryChan)
+				}
+			}
+		}
+
+		if streaming {
+			for _, sendPacket := range sendPackets {
+				state.Expect(sendPacket.RemoteAddr, 1)
+			}
+		}
+
+		return sendPackets
+
+	}
+
+	splitHandlerWrapper := func(packet Packet) bufio.SplitFunc {
+		protocolEntry, protocolExists := protColl.Get(packet.ProtocolId)
+		if !protocolExists {
+			return nil
+		}
+		return protocolEntry.Base.SplitFunc
+	}
+
+	identifyHandlerWrapper := func(packet Packet) string {
+		protocolName, pOk := state.ProtocolOf(packet.RemoteAddr)
+		if !pOk {
+			return ""
+		}
+		protocolEntry, protocolExists := protColl.Get(protocolName)
+		if !protocolExists || protocolEntry.Base.ResponseIdFunc == nil {
+			return ""
+		}
+		return protocolEntry.Base.ResponseIdFunc(packet, protocolEntry.Information)
+	}
+
+	retryHandlerWrapper := func(packet Packet) (int, time.Duration) {
+		protocolEntry, protocolExists := protColl.Get(packet.ProtocolId)
+		if !protocolExists {
+			return 0, 0
+		}
+		return RetryPolicy(protocolEntry.Information)
+	}
+
+	doneHandlerWrapper := func(request Packet) {
+		if entry, finalized := state.Settle(request.RemoteAddr); finalized {
+			emitEntry(completeEntry(entry))
+		}
+	}
+
+	var packetNum int
+	var failedEntries = []ServerEntry{}
+	var probePackets = []Packet{}
+	var probedHosts = map[string]bool{}
+	for _, packPair := range packErrPairs {
+		packet := packPair.Packet
+		packErr := packPair.Error
+
+		if packErr == nil {
+			mappedProtocolId := packet.ProtocolId
+			if _, isAuto := autoCandidates[packet.RemoteAddr]; isAuto {
+				mappedProtocolId = AUTO_PROTOCOL_ID
+			}
+			protocolMappingInChan <- HostProtocolIdPair{RemoteAddr: packet.RemoteAddr, ProtocolId: mappedProtocolId}
+			if streaming {
+				state.Expect(packet.RemoteAddr, 1)
+			}
+			sendPacketChan <- packet
+			packetNum++
+
+			protocol, _ := protColl.Get(packet.ProtocolId)
+			if !probedHosts[packet.RemoteAddr] && protocol.Base.ResponseType != "Server list" {
+				probedHosts[packet.RemoteAddr] = true
+				probePackets = append(probePackets, packet)
+				if streaming && opts.Probes > 1 {
+					state.Expect(packet.RemoteAddr, opts.Probes-1)
+				}
+			}
+		} else {
+			failedEntry := MakeErrorServerEntry(packet.RemoteAddr, packet.ProtocolId, packErr)
+			failedEntries = append(failedEntries, failedEntry)
+			if streaming {
+				emitEntry(failedEntry)
+			}
+			messageChan <- ConsoleMsg{Type: MSG_DEBUG, Message: fmt.Sprintf("%s - %s - %s", packet.ProtocolId, packet.RemoteAddr, packErr.Error())}
+		}
+	}
+
+	if packetNum > 0 {
+		networkSettings := NetworkSettings{ParseHandler: parseHandlerWrapper, SplitHandler: splitHandlerWrapper, IdentifyHandler: identifyHandlerWrapper, RetryHandler: retryHandlerWrapper, TimeOut: idleTimeout, SendRate: sendRate, MaxInFlight: maxInFlight, UDPSockets: opts.udpSockets}
+		if streaming {
+			networkSettings.DoneHandler = doneHandlerWrapper
+		}
+		if opts.Counters != nil {
+			networkSettings.SentHandler = func(packet Packet) { opts.Counters.PacketSent(packet.ProtocolId) }
+		}
+		go AsyncNetworkServer(serverInitChan, serverStopChan, queryCtx.Done(), messageChan, sendPacketChan, receivePacketChan, networkSettings)
+		<-serverInitChan
+		probeEndChan := make(chan struct{})
+		probeDoneChan := make(chan struct{})
+		go func() {
+			defer close(probeDoneChan)
+			if opts.Probes > 1 && len(probePackets) > 0 {
+				probeLoop(probeEndChan, sendPacketChan, probePackets, opts.Probes, probeInterval)
+			}
+		}()
+		<-serverStopChan
+		close(probeEndChan)
+		<-probeDoneChan
+	}
+
+	state.Close()
+	serverDataMap := state.Entries()
+	detectedProtocols := state.Detected()
+	finalizedEntries := state.Finalized()
+
+	for remoteAddr, protocolId := range state.Mapping() {
+		if _, exists := serverDataMap[remoteAddr]; !exists {
+			serverDataMap[remoteAddr] = MakeErrorServerEntry(remoteAddr, protocolId, ServerDown)
+		}
+	}
+	for _, entry := range failedEntries {
+		if _, exists := serverDataMap[entry.Host]; !exists {
+			serverDataMap[entry.Host] = entry
+		}
+	}
+
+	streamedFailures := map[string]bool{}
+	for _, entry := range failedEntries {
+		streamedFailures[entry.Host] = true
+	}
+
+	for _, entry := range serverDataMap {
+		if _, isAuto := autoCandidates[entry.Host]; isAuto {
+			entry.DetectedProtocols = append([]string{}, detectedProtocols[entry.Host]...)
+		}
+		entry = completeEntry(entry)
+		if entry.Error != nil {
+			result.Errors[entry.Host] = entry.Error
+		}
+		if streaming && !finalizedEntries[entry.Host] && !streamedFailures[entry.Host] {
+			emitEntry(entry)
+		}
+		result.Servers = append(result.Servers, entry)
+	}
+
+	return result, ctx.Err()
+}
@@ -0,0 +1,85 @@
+package grokstat
+
+import (
+	"net"
+	"sort"
+)
+
+// Hosts listed under this protocol id are probed with every server protocol to find out which ones they speak.
+const AUTO_PROTOCOL_ID = "auto"
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the ids of the protocols used to query servers rather than master servers.
+func ServerProtocolIds(protColl *ProtocolCollection) []string {
+	protocolIds := []string{}
+	for protocolId, protocol := range protColl.Map() {
+		if protocol.Base.ResponseType != "Server list" {
+			protocolIds = append(protocolIds, protocolId)
+		}
+	}
+	sort.Strings(protocolIds)
+	return protocolIds
+}
+
+// Makes the probes for the hosts of unknown type. If the host has a port, the protocols with matching default port are probed, or all server protocols if none matches. Without a port every server protocol is probed at its default port.
+// Probes with the same payload are sent only once per address. Returns the probes along with the candidate protocols for each probed address.
+func MakeAutoProbes(hosts []HostProtocolIdPair, protColl *ProtocolCollection) (packErrPairs []PacketErrorPair, candidates map[string][]string) {
+	packErrPairs = []PacketErrorPair{}
+	candidates = map[string][]string{}
+
+	protocols := protColl.Map()
+	serverProtocolIds := ServerProtocolIds(protColl)
+
+	for _, hostpair := range hosts {
+		host, port := SplitRemoteAddr(hostpair.RemoteAddr, "")
+		ipAddr, rErr := net.ResolveIPAddr("ip", host)
+		if rErr != nil {
+			packErrPairs = append(packErrPairs, PacketErrorPair{Packet: Packet{RemoteAddr: hostpair.RemoteAddr, ProtocolId: AUTO_PROTOCOL_ID}, Error: rErr})
+			continue
+		}
+
+		probeIds := serverProtocolIds
+		if port != "" {
+			matchingIds := []string{}
+			for _, protocolId := range serverProtocolIds {
+				if protocols[protocolId].Information["DefaultRequestPort"] == port {
+					matchingIds = append(matchingIds, protocolId)
+				}
+			}
+			if len(matchingIds) > 0 {
+				probeIds = matchingIds
+			}
+		}
+
+		sentPayloads := map[string]bool{}
+		for _, protocolId := range probeIds {
+			probePort := port
+			if probePort == "" {
+				probePort = protocols[protocolId].Information["DefaultRequestPort"]
+			}
+			remoteAddr := net.JoinHostPort(ipAddr.String(), QueryPort(probePort, protocols[protocolId].Information))
+			if !containsString(candidates[remoteAddr], protocolId) {
+				candidates[remoteAddr] = append(candidates[remoteAddr], protocolId)
+			}
+
+			for _, packet := range MakeSendPackets(HostProtocolIdPair{RemoteAddr: remoteAddr, ProtocolId: protocolId, Hostname: host}, protColl) {
+				payloadKey := packet.Type.Network() + "/" + remoteAddr + "/" + string(packet.Data)
+				if sentPayloads[payloadKey] {
+					continue
+				}
+				sentPayloads[payloadKey] = true
+				packErrPairs = append(packErrPairs, PacketErrorPair{Packet: packet})
+			}
+		}
+	}
+
+	return packErrPairs, candidates
+}
@@ -0,0 +1,59 @@
+package grokstat
+
+import (
+	"sync"
+)
+
+// Packets which could not be attributed to any protocol are counted under this protocol id.
+const UNKNOWN_PROTOCOL_ID = "unknown"
+
+// QueryCounters count the packets and parse errors by protocol id. A single instance may be shared by any number of queries.
+type QueryCounters struct {
+	sync.Mutex
+	packetsSent     map[string]uint64
+	packetsReceived map[string]uint64
+	parseErrors     map[string]uint64
+}
+
+// Copy of the counter values by protocol id.
+type QueryCountersSnapshot struct {
+	PacketsSent     map[string]uint64
+	PacketsReceived map[string]uint64
+	ParseErrors     map[string]uint64
+}
+
+func MakeQueryCounters() *QueryCounters {
+	return &QueryCounters{packetsSent: map[string]uint64{}, packetsReceived: map[string]uint64{}, parseErrors: map[string]uint64{}}
+}
+
+func (c *QueryCounters) add(counter map[string]uint64, protocolId string) {
+	c.Lock()
+	defer c.Unlock()
+	counter[protocolId]++
+}
+
+func (c *QueryCounters) PacketSent(protocolId string) {
+	c.add(c.packetsSent, protocolId)
+}
+
+func (c *QueryCounters) PacketReceived(protocolId string) {
+	c.add(c.packetsReceived, protocolId)
+}
+
+func (c *QueryCounters) ParseError(protocolId string) {
+	c.add(c.parseErrors, protocolId)
+}
+
+func copyCounter(counter map[string]uint64) map[string]uint64 {
+	m := make(map[string]uint64, len(counter))
+	for k, v := range counter {
+		m[k] = v
+	}
+	return m
+}
+
+func (c *QueryCounters) Snapshot() QueryCountersSnapshot {
+	c.Lock()
+	defer c.Unlock()
+	return QueryCountersSnapshot{PacketsSent: copyCounter(c.packetsSent), PacketsReceived: copyCounter(c.packetsReceived), ParseErrors: copyCounter(c.parseErrors)}
+}
@@ -0,0 +1,226 @@
+package grokstat
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/imdario/mergo"
+)
+
+// QueryState holds the server to protocol mapping and the server entries collected during a single query. Error entries other than server down are counted as parse errors if counters are given.
+// The state is owned by its loop goroutine which is started by MakeQueryState and stopped by Close. The collected data may only be read after Close.
+type QueryState struct {
+	mappingChan chan HostProtocolIdPair
+	entryChan   chan ServerEntry
+	lookupChan  chan func()
+	endChan     chan struct{}
+	doneChan    chan struct{}
+	closeOnce   sync.Once
+
+	mapping   map[string]string
+	entries   map[string]ServerEntry
+	detected  map[string][]string
+	pending   map[string]int
+	finalized map[string]bool
+	counters  *QueryCounters
+}
+
+func MakeQueryState(counters *QueryCounters) *QueryState {
+	s := &QueryState{
+		mappingChan: make(chan HostProtocolIdPair),
+		entryChan:   make(chan ServerEntry),
+		lookupChan:  make(chan func()),
+		endChan:     make(chan struct{}),
+		doneChan:    make(chan struct{}),
+		mapping:     map[string]string{},
+		entries:     map[string]ServerEntry{},
+		detected:    map[string][]string{},
+		pending:     map[string]int{},
+		finalized:   map[string]bool{},
+		counters:    counters,
+	}
+	go s.loop()
+	return s
+}
+
+func (s *QueryState) loop() {
+	defer close(s.doneChan)
+	for {
+		select {
+		case pair := <-s.mappingChan:
+			s.mapping[pair.RemoteAddr] = pair.ProtocolId
+		case entry := <-s.entryChan:
+			if s.counters != nil && entry.Error != nil && entry.Error != ServerDown {
+				s.counters.ParseError(entry.Protocol)
+			}
+			s.merge(entry)
+		case lookup := <-s.lookupChan:
+			lookup()
+		case <-s.endChan:
+			return
+		}
+	}
+}
+
+// Merges the entry into the one collected for the same host. Error entries never replace successful ones.
+// Player lists are not merged, the longer one is kept. Handlers collecting the players from several responses send the players received so far.
+func (s *QueryState) merge(serverEntry ServerEntry) {
+	hostname := serverEntry.Host
+
+	oldEntry, exists := s.entries[hostname]
+	if !exists || (oldEntry.Error != nil && serverEntry.Error == nil) {
+		s.entries[hostname] = serverEntry
+		return
+	}
+	if serverEntry.Error != nil {
+		return
+	}
+
+	mergedEntry := oldEntry
+	mergedRules := map[string]string{}
+
+	for k, v := range mergedEntry.Rules {
+		mergedRules[k] = v
+	}
+
+	mergo.Merge(&mergedEntry, serverEntry)
+	mergo.Merge(&mergedRules, serverEntry.Rules)
+	if len(serverEntry.Players) > len(oldEntry.Players) {
+		mergedEntry.Players = serverEntry.Players
+	}
+
+	mergedEntry.Rules = mergedRules
+
+	s.entries[hostname] = mergedEntry
+}
+
+// Channel receiving the server to protocol mappings. Handlers send to it while the state is running.
+func (s *QueryState) MappingChan() chan<- HostProtocolIdPair {
+	return s.mappingChan
+}
+
+// Channel receiving the server entries. Handlers send to it while the state is running.
+func (s *QueryState) EntryChan() chan<- ServerEntry {
+	return s.entryChan
+}
+
+// Runs the function on the loop goroutine, or directly once the loop has exited.
+func (s *QueryState) do(f func()) {
+	doneChan := make(chan struct{})
+	select {
+	case s.lookupChan <- func() {
+		f()
+		close(doneChan)
+	}:
+		<-doneChan
+	case <-s.doneChan:
+		f()
+	}
+}
+
+// Returns the protocol the server is queried with.
+func (s *QueryState) ProtocolOf(remoteAddr string) (protocolId string, exists bool) {
+	s.do(func() {
+		protocolId, exists = s.mapping[remoteAddr]
+	})
+	return protocolId, exists
+}
+
+// Returns the protocols used in the query. The ones used for the servers at the same IP address come first.
+func (s *QueryState) ProtocolsByAffinity(remoteAddr string) []string {
+	host, _ := SplitRemoteAddr(remoteAddr, "")
+	sameHost := map[string]bool{}
+	used := map[string]bool{}
+	s.do(func() {
+		for k, v := range s.mapping {
+			used[v] = true
+			if h, _, err := net.SplitHostPort(k); err == nil && h == host {
+				sameHost[v] = true
+			}
+		}
+	})
+
+	for protocolId := range sameHost {
+		delete(used, protocolId)
+	}
+
+	protocolIds := []string{}
+	for _, group := range []map[string]bool{sameHost, used} {
+		groupIds := []string{}
+		for protocolId := range group {
+			groupIds = append(groupIds, protocolId)
+		}
+		sort.Strings(groupIds)
+		protocolIds = append(protocolIds, groupIds...)
+	}
+	return protocolIds
+}
+
+// Registers the protocol as one the server has answered with.
+func (s *QueryState) AddDetected(remoteAddr string, protocolId string) {
+	s.do(func() {
+		if !containsString(s.detected[remoteAddr], protocolId) {
+			s.detected[remoteAddr] = append(s.detected[remoteAddr], protocolId)
+		}
+	})
+}
+
+// Registers the number of requests made to the server which are yet to be answered or given up.
+func (s *QueryState) Expect(remoteAddr string, num int) {
+	s.do(func() {
+		s.pending[remoteAddr] += num
+	})
+}
+
+// Registers the request to the server as answered or given up. Once the server has no requests left its entry is finalized and returned, a server down entry if it has not answered at all.
+// Each server entry is finalized only once.
+func (s *QueryState) Settle(remoteAddr string) (entry ServerEntry, finalized bool) {
+	s.do(func() {
+		if s.pending[remoteAddr] <= 0 || s.finalized[remoteAddr] {
+			return
+		}
+		s.pending[remoteAddr]--
+		if s.pending[remoteAddr] > 0 {
+			return
+		}
+		var exists bool
+		if entry, exists = s.entries[remoteAddr]; !exists {
+			entry = MakeErrorServerEntry(remoteAddr, s.mapping[remoteAddr], ServerDown)
+		}
+		if detected, isDetected := s.detected[remoteAddr]; isDetected {
+			entry.DetectedProtocols = append([]string{}, detected...)
+		}
+		s.finalized[remoteAddr] = true
+		finalized = true
+	})
+	return entry, finalized
+}
+
+// Stops the loop and waits for it to exit.
+func (s *QueryState) Close() {
+	s.closeOnce.Do(func() {
+		close(s.endChan)
+	})
+	<-s.doneChan
+}
+
+// Returns the server to protocol mapping. Must be called after Close.
+func (s *QueryState) Mapping() map[string]string {
+	return s.mapping
+}
+
+// Returns the protocols each server has answered with. Must be called after Close.
+func (s *QueryState) Detected() map[string][]string {
+	return s.detected
+}
+
+// Returns the servers whose entries have been finalized by Settle. Must be called after Close.
+func (s *QueryState) Finalized() map[string]bool {
+	return s.finalized
+}
+
+// Returns the merged server entries by host. Must be called after Close.
+func (s *QueryState) Entries() map[string]ServerEntry {
+	return s.entries
+}
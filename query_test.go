@@ -0,0 +1,288 @@
+package grokstat
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestQueryCancel(t *testing.T) {
+	conn, lErr := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	defer conn.Close()
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: conn.LocalAddr().String(), ProtocolId: "mumbles"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := Query(ctx, hosts, QueryOptions{Protocols: protColl, IdleTimeout: 10 * time.Second})
+	elapsed := time.Since(start)
+
+	if err != context.DeadlineExceeded {
+		t.Errorf(ErrorOut(context.DeadlineExceeded, err))
+	}
+
+	if elapsed > 2*time.Second {
+		t.Errorf(ErrorOut("< 2s", elapsed))
+	}
+}
+
+func TestQueryStatusEntries(t *testing.T) {
+	var err error
+	conn, lErr := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	defer conn.Close()
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: conn.LocalAddr().String(), ProtocolId: "mumbles"}, HostProtocolIdPair{RemoteAddr: "127.0.0.1:1", ProtocolId: "bogus"}}
+	expectation := map[string]int{conn.LocalAddr().String(): 503, "127.0.0.1:1": 400}
+
+	result, resultErr := Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: 300 * time.Millisecond})
+
+	if resultErr != nil {
+		t.Errorf(resultErr.Error())
+	}
+
+	statuses := map[string]int{}
+	for _, entry := range result.Servers {
+		statuses[entry.Host] = entry.Status
+	}
+
+	if len(statuses) != len(expectation) || len(result.Errors) != len(expectation) {
+		err = CompError
+	}
+	for k := range expectation {
+		if statuses[k] != expectation[k] {
+			err = CompError
+		}
+	}
+
+	if err != nil {
+		t.Errorf(ErrorOut(expectation, statuses))
+	}
+}
+
+// Starts a Mumble server stub which ignores the first dropNum requests.
+func startMumbleStub(t *testing.T, dropNum int) *net.UDPConn {
+	conn, lErr := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, addr, rErr := conn.ReadFromUDP(buf)
+			if rErr != nil {
+				return
+			}
+			if n != 12 {
+				continue
+			}
+			if dropNum > 0 {
+				dropNum--
+				continue
+			}
+			response := append([]byte{0x00, 0x01, 0x02, 0x04}, buf[4:12]...)
+			response = append(response, make([]byte, 12)...)
+			conn.WriteToUDP(response, addr)
+		}
+	}()
+	return conn
+}
+
+func TestQueryPingProbes(t *testing.T) {
+	conn := startMumbleStub(t, 0)
+	defer conn.Close()
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: conn.LocalAddr().String(), ProtocolId: "mumbles"}}
+	expectation := 3
+
+	result, err := Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: 300 * time.Millisecond, Probes: expectation, ProbeInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Servers) != 1 || result.Servers[0].PingStats == nil {
+		t.Fatalf(ErrorOut(expectation, result.Servers))
+	}
+
+	stats := result.Servers[0].PingStats
+	if stats.Samples != expectation || stats.Min <= 0 || stats.Min > stats.Avg || stats.Avg > stats.Max {
+		t.Errorf(ErrorOut(expectation, *stats))
+	}
+}
+
+func TestQueryRetries(t *testing.T) {
+	conn := startMumbleStub(t, 2)
+	defer conn.Close()
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "mumbles", Template: "MUMBLES", Overrides: map[string]string{"Retries": "2", "RetryBackoff": "50"}}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: conn.LocalAddr().String(), ProtocolId: "mumbles"}}
+	expectation := 200
+
+	result, err := Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Servers) != 1 || result.Servers[0].Status != expectation {
+		t.Errorf(ErrorOut(expectation, result.Servers))
+	}
+}
+
+func TestQueryEntryHandler(t *testing.T) {
+	conn := startMumbleStub(t, 0)
+	defer conn.Close()
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: conn.LocalAddr().String(), ProtocolId: "mumbles"}, HostProtocolIdPair{RemoteAddr: "127.0.0.1:1", ProtocolId: "bogus"}}
+	expectation := map[string]int{conn.LocalAddr().String(): 200, "127.0.0.1:1": 400}
+
+	idleTimeout := time.Second
+	start := time.Now()
+	statuses := map[string]int{}
+	emitTimes := map[string]time.Duration{}
+	entryHandler := func(entry ServerEntry) {
+		if _, exists := statuses[entry.Host]; exists {
+			t.Errorf("Entry for %s passed twice.", entry.Host)
+		}
+		statuses[entry.Host] = entry.Status
+		emitTimes[entry.Host] = time.Since(start)
+	}
+
+	_, err := Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: idleTimeout, EntryHandler: entryHandler})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(statuses, expectation) {
+		t.Errorf(ErrorOut(expectation, statuses))
+	}
+	if emitTimes[conn.LocalAddr().String()] >= idleTimeout {
+		t.Errorf(ErrorOut(idleTimeout, emitTimes))
+	}
+}
+
+func TestQueryCounters(t *testing.T) {
+	conn := startMumbleStub(t, 0)
+	defer conn.Close()
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: conn.LocalAddr().String(), ProtocolId: "mumbles"}}
+	counters := MakeQueryCounters()
+	expectation := QueryCountersSnapshot{PacketsSent: map[string]uint64{"mumbles": 2}, PacketsReceived: map[string]uint64{"mumbles": 2}, ParseErrors: map[string]uint64{}}
+
+	for i := 0; i < 2; i++ {
+		if _, err := Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: 200 * time.Millisecond, Counters: counters}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result := counters.Snapshot()
+	if !reflect.DeepEqual(result, expectation) {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestQueryRepeated(t *testing.T) {
+	conn := startMumbleStub(t, 0)
+	defer conn.Close()
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: conn.LocalAddr().String(), ProtocolId: "mumbles"}}
+
+	expectation := runtime.NumGoroutine()
+
+	for i := 0; i < 3; i++ {
+		result, err := Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: 100 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Servers) != 1 || result.Servers[0].Status != 200 {
+			t.Errorf(ErrorOut(200, result.Servers))
+		}
+	}
+
+	if result := runtime.NumGoroutine(); result > expectation {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestIdentifyPacketProtocol(t *testing.T) {
+	var err error
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "q3s", Template: "Q3S"}, ProtocolConfig{Id: "sof2s", Template: "Q3S"}, ProtocolConfig{Id: "a2s", Template: "A2S"}, ProtocolConfig{Id: "steam", Template: "STEAM"}, ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+
+	testCases := []struct {
+		data      string
+		preferred []string
+		protocol  string
+	}{
+		{"\xFF\xFF\xFF\xFFstatusResponse\n\\sv_hostname\\grokstat\n", nil, "q3s"},
+		{"\xFF\xFF\xFF\xFFstatusResponse\n\\sv_hostname\\grokstat\n", []string{"sof2s"}, "sof2s"},
+		{"\xFF\xFF\xFF\xFFI\x11grokstat\x00", nil, "a2s"},
+		{"\xFF\xFF\xFF\xFF\x66\x0A\x7F\x00\x00\x01\x69\x87", nil, "steam"},
+		{"\x00\x01\x02\x04grokstat\x00\x00\x00\x01\x00\x00\x00\x10\x00\x00\x00\x00", nil, "mumbles"},
+		{"\x00\x01\x02", nil, ""},
+	}
+
+	expectation := []string{}
+	result := []string{}
+	for _, testCase := range testCases {
+		protocolId, _ := IdentifyPacketProtocol(Packet{Data: []byte(testCase.data)}, protColl, testCase.preferred)
+		expectation = append(expectation, testCase.protocol)
+		result = append(result, protocolId)
+		if protocolId != testCase.protocol {
+			err = CompError
+		}
+	}
+
+	if err != nil {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestMakeAutoProbes(t *testing.T) {
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "q3s", Template: "Q3S"}, ProtocolConfig{Id: "xonotics", Template: "Q3S"}, ProtocolConfig{Id: "q3m", Template: "Q3M"}, ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: "127.0.0.1:27950", ProtocolId: AUTO_PROTOCOL_ID}}
+	expectation := map[string][]string{"127.0.0.1:27950": []string{"q3s", "xonotics"}}
+
+	packErrPairs, result := MakeAutoProbes(hosts, protColl)
+
+	if len(packErrPairs) != 1 || len(result) != 1 || len(result["127.0.0.1:27950"]) != 2 || result["127.0.0.1:27950"][1] != "xonotics" {
+		t.Errorf(ErrorOut(expectation, result))
+	}
+}
+
+func TestQueryAuto(t *testing.T) {
+	conn := startMumbleStub(t, 0)
+	defer conn.Close()
+
+	protColl := LoadProtocols([]ProtocolConfig{ProtocolConfig{Id: "q3s", Template: "Q3S"}, ProtocolConfig{Id: "a2s", Template: "A2S"}, ProtocolConfig{Id: "mumbles", Template: "MUMBLES"}})
+	hosts := []HostProtocolIdPair{HostProtocolIdPair{RemoteAddr: conn.LocalAddr().String(), ProtocolId: AUTO_PROTOCOL_ID}}
+	expectation := ServerEntry{Host: conn.LocalAddr().String(), Protocol: "mumbles", Status: 200, DetectedProtocols: []string{"mumbles"}}
+
+	result, err := Query(context.Background(), hosts, QueryOptions{Protocols: protColl, IdleTimeout: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Servers) != 1 {
+		t.Fatalf(ErrorOut(expectation, result.Servers))
+	}
+	entry := result.Servers[0]
+	if entry.Host != expectation.Host || entry.Protocol != expectation.Protocol || entry.Status != expectation.Status || len(entry.DetectedProtocols) != 1 || entry.DetectedProtocols[0] != "mumbles" {
+		t.Errorf(ErrorOut(expectation, entry))
+	}
+}